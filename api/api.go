@@ -0,0 +1,202 @@
+// Package api serves the HTTP control API of a running docker-firewall, over
+// a unix socket and optionally TCP, where a token is required
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultSocket is the unix socket the API listens on by default
+const DefaultSocket = "/var/run/docker-firewall.sock"
+
+// Status defines the state of the rules of a running docker-firewall
+type Status struct {
+	// LastApply is when the rules were last applied successfully
+	LastApply time.Time `json:"last_apply"`
+	// LastVerify is when the rules were last verified
+	LastVerify time.Time `json:"last_verify"`
+	// Verified is the result of the last verification
+	Verified bool `json:"verified"`
+	// Drift is true when the last verification found the rules changed and
+	// they were not applied again yet
+	Drift bool `json:"drift"`
+	// Error is the last reconciliation error, empty once it succeeds
+	Error string `json:"error,omitempty"`
+	// ConfirmDeadline is when the rules applied by a reload waiting for
+	// confirmation are rolled back
+	ConfirmDeadline *time.Time `json:"confirm_deadline,omitempty"`
+	// IgnoredLabels are the container labels no rule was made of, and why
+	IgnoredLabels []string `json:"ignored_labels,omitempty"`
+}
+
+// Controller is the running docker-firewall driven by the API
+type Controller interface {
+	// Rules returns the rendered rules of each IP protocol
+	Rules(ctx context.Context) (map[string][]string, error)
+	// Status returns the state of the rules
+	Status() Status
+	// Apply applies the rules again
+	Apply(ctx context.Context) error
+	// Reload reads the configuration again and applies it. When the confirm
+	// timeout is not zero, the previous rules are restored unless Confirm is
+	// called in time.
+	Reload(ctx context.Context, confirmTimeout time.Duration) error
+	// Confirm keeps the rules applied by a reload waiting for confirmation
+	Confirm(ctx context.Context) error
+}
+
+// NewHandler returns the API handler driving the controller
+func NewHandler(controller Controller) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/rules", method(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		rules, err := controller.Rules(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, rules)
+	}))
+
+	mux.HandleFunc("/status", method(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, controller.Status())
+	}))
+
+	mux.HandleFunc("/apply", method(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		err := controller.Apply(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, controller.Status())
+	}))
+
+	mux.HandleFunc("/reload", method(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		var confirmTimeout time.Duration
+		if value := r.URL.Query().Get("confirm_timeout"); value != "" {
+			var err error
+			confirmTimeout, err = time.ParseDuration(value)
+			if err != nil || confirmTimeout < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid confirm_timeout %q", value)})
+				return
+			}
+		}
+
+		err := controller.Reload(r.Context(), confirmTimeout)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, controller.Status())
+	}))
+
+	mux.HandleFunc("/confirm", method(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		err := controller.Confirm(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, controller.Status())
+	}))
+
+	return mux
+}
+
+// RequireToken only lets the requests holding the token as a bearer
+// Authorization header through to the handler
+func RequireToken(token string, handler http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid token"})
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Listen listens on a unix socket, replacing a socket left behind by a
+// previous run, or on a TCP address
+func Listen(network, address string) (net.Listener, error) {
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(address, 0660)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Serve serves the handler on the listener until the context is done
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err := server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// method only lets requests with the given method through
+func method(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != name {
+			w.Header().Set("Allow", name)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("failed to write the API response: %v", err)
+	}
+}
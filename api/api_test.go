@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeController struct {
+	status         Status
+	applied        int
+	reload         error
+	confirmTimeout time.Duration
+	confirm        error
+}
+
+func (f *fakeController) Rules(ctx context.Context) (map[string][]string, error) {
+	return map[string][]string{"IPv4": {"-p tcp -m tcp --dport 8080 -j RETURN", "-j DROP"}}, nil
+}
+
+func (f *fakeController) Status() Status {
+	return f.status
+}
+
+func (f *fakeController) Apply(ctx context.Context) error {
+	f.applied++
+	f.status.LastApply = time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	return nil
+}
+
+func (f *fakeController) Reload(ctx context.Context, confirmTimeout time.Duration) error {
+	f.confirmTimeout = confirmTimeout
+	return f.reload
+}
+
+func (f *fakeController) Confirm(ctx context.Context) error {
+	return f.confirm
+}
+
+type APITestSuite struct {
+	suite.Suite
+	controller *fakeController
+	handler    http.Handler
+}
+
+func TestAPITestSuite(t *testing.T) {
+	suite.Run(t, new(APITestSuite))
+}
+
+func (a *APITestSuite) SetupTest() {
+	a.controller = &fakeController{}
+	a.handler = NewHandler(a.controller)
+}
+
+func (a *APITestSuite) request(method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	a.handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+
+	return recorder
+}
+
+func (a *APITestSuite) Test_Rules() {
+	response := a.request(http.MethodGet, "/rules")
+	a.Equal(http.StatusOK, response.Code)
+	a.Equal("application/json", response.Header().Get("Content-Type"))
+	a.JSONEq(`{"IPv4":["-p tcp -m tcp --dport 8080 -j RETURN","-j DROP"]}`, response.Body.String())
+}
+
+func (a *APITestSuite) Test_Status() {
+	a.controller.status = Status{
+		LastVerify: time.Date(2018, 5, 1, 10, 0, 10, 0, time.UTC),
+		Drift:      true,
+		Error:      "error applying IPv4 rules: exit status 1",
+	}
+
+	response := a.request(http.MethodGet, "/status")
+	a.Equal(http.StatusOK, response.Code)
+	a.JSONEq(`{
+		"last_apply": "0001-01-01T00:00:00Z",
+		"last_verify": "2018-05-01T10:00:10Z",
+		"verified": false,
+		"drift": true,
+		"error": "error applying IPv4 rules: exit status 1"
+	}`, response.Body.String())
+}
+
+func (a *APITestSuite) Test_Apply() {
+	response := a.request(http.MethodPost, "/apply")
+	a.Equal(http.StatusOK, response.Code)
+	a.Equal(1, a.controller.applied)
+	a.Contains(response.Body.String(), `"last_apply":"2018-05-01T10:00:00Z"`)
+
+	response = a.request(http.MethodGet, "/apply")
+	a.Equal(http.StatusMethodNotAllowed, response.Code)
+	a.Equal(http.MethodPost, response.Header().Get("Allow"))
+	a.Equal(1, a.controller.applied)
+}
+
+func (a *APITestSuite) Test_Reload() {
+	a.controller.reload = errors.New("configuration error in rule 0: unknown protocol \"tpc\"")
+
+	response := a.request(http.MethodPost, "/reload")
+	a.Equal(http.StatusInternalServerError, response.Code)
+	a.JSONEq(`{"error":"configuration error in rule 0: unknown protocol \"tpc\""}`, response.Body.String())
+
+	a.controller.reload = nil
+	response = a.request(http.MethodPost, "/reload?confirm_timeout=1m30s")
+	a.Equal(http.StatusOK, response.Code)
+	a.Equal(90*time.Second, a.controller.confirmTimeout)
+
+	response = a.request(http.MethodPost, "/reload?confirm_timeout=soon")
+	a.Equal(http.StatusBadRequest, response.Code)
+	a.JSONEq(`{"error":"invalid confirm_timeout \"soon\""}`, response.Body.String())
+}
+
+func (a *APITestSuite) Test_Confirm() {
+	deadline := time.Date(2018, 5, 1, 10, 1, 0, 0, time.UTC)
+	a.controller.status.ConfirmDeadline = &deadline
+
+	response := a.request(http.MethodGet, "/status")
+	a.Contains(response.Body.String(), `"confirm_deadline":"2018-05-01T10:01:00Z"`)
+
+	response = a.request(http.MethodPost, "/confirm")
+	a.Equal(http.StatusOK, response.Code)
+
+	a.controller.confirm = errors.New("no reload is waiting for confirmation")
+	response = a.request(http.MethodPost, "/confirm")
+	a.Equal(http.StatusConflict, response.Code)
+	a.JSONEq(`{"error":"no reload is waiting for confirmation"}`, response.Body.String())
+}
+
+func (a *APITestSuite) Test_RequireToken() {
+	handler := RequireToken("s3cret", a.handler)
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cret"} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/apply", nil)
+		request.Header.Set("Authorization", authorization)
+		handler.ServeHTTP(recorder, request)
+
+		a.Equal(http.StatusUnauthorized, recorder.Code, authorization)
+		a.JSONEq(`{"error":"missing or invalid token"}`, recorder.Body.String())
+	}
+	a.Equal(0, a.controller.applied)
+
+	listener, err := Listen("tcp", "127.0.0.1:0")
+	a.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, listener, handler)
+	}()
+
+	client := NewClient("tcp", listener.Addr().String())
+	a.EqualError(client.Apply(context.Background()), "missing or invalid token")
+
+	client.SetToken("s3cret")
+	a.NoError(client.Apply(context.Background()))
+	a.Equal(1, a.controller.applied)
+
+	cancel()
+	a.NoError(<-done)
+}
+
+func (a *APITestSuite) Test_Serve() {
+	dir, err := ioutil.TempDir("", "docker-firewall")
+	a.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "api.sock")
+	listener, err := Listen("unix", socket)
+	a.Require().NoError(err)
+	listener.Close()
+
+	// a socket left behind is replaced
+	listener, err = Listen("unix", socket)
+	a.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, listener, a.handler)
+	}()
+
+	client := NewClient("unix", socket)
+	status, err := client.Status(context.Background())
+	a.NoError(err)
+	a.Equal(Status{}, status)
+
+	_, err = client.Reload(context.Background(), time.Minute)
+	a.NoError(err)
+	a.Equal(time.Minute, a.controller.confirmTimeout)
+
+	a.controller.reload = errors.New("invalid configuration")
+	_, err = client.Reload(context.Background(), 0)
+	a.EqualError(err, "invalid configuration")
+
+	a.controller.confirm = errors.New("no reload is waiting for confirmation")
+	err = client.Confirm(context.Background())
+	a.EqualError(err, "no reload is waiting for confirmation")
+
+	cancel()
+	a.NoError(<-done)
+}
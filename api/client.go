@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client talks to the API of a running docker-firewall
+type Client struct {
+	http  *http.Client
+	host  string
+	token string
+}
+
+// NewClient returns a Client for the API listening on the unix socket or TCP address
+func NewClient(network, address string) *Client {
+	host := "docker-firewall"
+	if network != "unix" {
+		host = address
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+
+	return &Client{http: &http.Client{Transport: transport}, host: host}
+}
+
+// SetToken sets the token sent with the requests, required over TCP
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Rules returns the rendered rules of each IP protocol
+func (c *Client) Rules(ctx context.Context) (map[string][]string, error) {
+	var rules map[string][]string
+	err := c.do(ctx, http.MethodGet, "/rules", &rules)
+
+	return rules, err
+}
+
+// Status returns the state of the rules
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodGet, "/status", &status)
+
+	return status, err
+}
+
+// Apply applies the rules again
+func (c *Client) Apply(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/apply", nil)
+}
+
+// Reload reads the configuration again and applies it, waiting for
+// confirmation when the confirm timeout is not zero
+func (c *Client) Reload(ctx context.Context, confirmTimeout time.Duration) (Status, error) {
+	path := "/reload"
+	if confirmTimeout > 0 {
+		path += "?confirm_timeout=" + url.QueryEscape(confirmTimeout.String())
+	}
+
+	var status Status
+	err := c.do(ctx, http.MethodPost, path, &status)
+
+	return status, err
+}
+
+// Confirm keeps the rules applied by a reload waiting for confirmation
+func (c *Client) Confirm(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/confirm", nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, "http://"+c.host+path, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("docker-firewall API %s returned %s", path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode the response of %s: %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/albertogviana/docker-firewall/api"
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/docker"
+	"github.com/albertogviana/docker-firewall/firewall"
+	"github.com/albertogviana/docker-firewall/metrics"
+)
+
+// daemon keeps the rules of the configuration in place and records their
+// status for the API and the metrics
+type daemon struct {
+	firewall *firewall.Firewall
+	client   *docker.Client
+	metrics  *metrics.Metrics
+
+	mu            sync.Mutex
+	configuration *config.Configuration
+	applied       []config.Rule
+	status        api.Status
+	pending       *pendingReload
+	watching      bool
+}
+
+// pendingReload is a reload waiting for confirmation, holding what to
+// restore when it is not confirmed
+type pendingReload struct {
+	snapshot      *firewall.Snapshot
+	configuration *config.Configuration
+	applied       []config.Rule
+	timer         *time.Timer
+}
+
+func newDaemon(fw *firewall.Firewall, configuration *config.Configuration, client *docker.Client) *daemon {
+	d := &daemon{firewall: fw, client: client, metrics: metrics.New()}
+	d.setConfiguration(configuration)
+
+	return d
+}
+
+// setConfiguration sets the configuration the rules are rendered from,
+// the lock must be held once the daemon runs
+func (d *daemon) setConfiguration(configuration *config.Configuration) {
+	d.configuration = configuration
+	d.firewall.SetDefaultPolicy(configuration.Config.DefaultPolicy, configuration.Config.LogDefault)
+	d.firewall.SetOptimize(configuration.Config.Optimize)
+	d.firewall.SetIPSet(configuration.Config.IPSet)
+}
+
+// rules returns the rules of the configuration file, completed with the
+// container labels and adapted to the Swarm services when enabled
+func (d *daemon) rules(ctx context.Context) ([]config.Rule, error) {
+	rules := d.configuration.Config.Rules
+
+	if labels && d.client != nil {
+		labelRules, ignored, err := d.client.LabelRules(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the container labels: %v", err)
+		}
+		d.status.IgnoredLabels = ignored
+		rules = append(append([]config.Rule{}, rules...), labelRules...)
+	}
+
+	if (swarm || d.configuration.Config.Swarm) && d.client != nil {
+		meshRules, err := d.client.SwarmRules(ctx, rules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the swarm services: %v", err)
+		}
+		rules = meshRules
+	}
+
+	return rules, nil
+}
+
+// reconcile applies the rules, unless force is false and they are in place
+func (d *daemon) reconcile(ctx context.Context, force bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.reconcileLocked(ctx, force)
+}
+
+func (d *daemon) reconcileLocked(ctx context.Context, force bool) error {
+	rules, err := d.rules(ctx)
+	if err != nil {
+		return d.fail(err)
+	}
+
+	if !force {
+		verified, err := d.firewall.Verify(rules)
+		if err != nil {
+			d.metrics.VerifyFailed()
+			return d.fail(err)
+		}
+
+		d.status.LastVerify = time.Now()
+		d.status.Verified = verified
+		if verified {
+			d.status.Error = ""
+			return nil
+		}
+
+		d.status.Drift = true
+		d.metrics.DriftDetected()
+		log.Println("Applying rules again.")
+	}
+
+	return d.apply(rules)
+}
+
+func (d *daemon) apply(rules []config.Rule) error {
+	start := time.Now()
+	err := d.firewall.Apply(rules)
+	d.metrics.ObserveApply(time.Since(start), err)
+	if err != nil {
+		return d.fail(err)
+	}
+
+	if d.configuration.Config.Optimize {
+		reports := d.firewall.Optimization(rules)
+		for _, family := range []string{"IPv4", "IPv6"} {
+			if report, ok := reports[family]; ok {
+				log.Printf("%s: %s", family, report)
+			}
+		}
+	}
+
+	d.applied = rules
+	d.status.LastApply = time.Now()
+	d.status.Drift = false
+	d.status.Error = ""
+
+	return nil
+}
+
+func (d *daemon) fail(err error) error {
+	d.status.Error = err.Error()
+
+	return err
+}
+
+// Rules returns the rendered rules of each IP protocol
+func (d *daemon) Rules(ctx context.Context) (map[string][]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rules, err := d.rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.firewall.Render(rules), nil
+}
+
+// Counters returns the counters of the applied rules
+func (d *daemon) Counters() ([]firewall.RuleCounter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.firewall.Counters(d.applied)
+}
+
+// Status returns the state of the rules
+func (d *daemon) Status() api.Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := d.status
+	if status.ConfirmDeadline != nil {
+		deadline := *status.ConfirmDeadline
+		status.ConfirmDeadline = &deadline
+	}
+
+	return status
+}
+
+// Apply applies the rules again
+func (d *daemon) Apply(ctx context.Context) error {
+	return d.reconcile(ctx, true)
+}
+
+// Reload reads the configuration file again and applies it, the current
+// configuration is kept when the new one is invalid. With a confirm timeout,
+// the rules in place are restored unless the reload is confirmed in time.
+// No reload is applied while one waits for confirmation, and a reload only
+// waits for confirmation when the configuration files are not watched, so
+// the rules restored are always the ones last confirmed.
+func (d *daemon) Reload(ctx context.Context, confirmTimeout time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.pending != nil {
+		return errors.New("a reload is waiting for confirmation, confirm it or wait for its rollback first")
+	}
+
+	if confirmTimeout > 0 && d.watching {
+		return errors.New("the configuration files are watched and applied without confirmation, start the service with --watch=false to confirm reloads")
+	}
+
+	configuration, err := config.NewConfiguration(configPath)
+	if err != nil {
+		d.metrics.ObserveReload(err)
+		return fmt.Errorf("failed to read the configuration file: %v", err)
+	}
+
+	var pending *pendingReload
+	if confirmTimeout > 0 {
+		snapshot, err := d.firewall.Snapshot()
+		if err != nil {
+			return fmt.Errorf("failed to save the rules in place: %v", err)
+		}
+
+		pending = &pendingReload{snapshot: snapshot, configuration: d.configuration, applied: d.applied}
+	}
+
+	d.setConfiguration(configuration)
+	log.Println("Configuration reloaded")
+
+	err = d.reconcileLocked(ctx, true)
+	d.metrics.ObserveReload(err)
+
+	if pending == nil {
+		return err
+	}
+
+	if err != nil {
+		d.rollbackLocked(pending)
+		return err
+	}
+
+	deadline := time.Now().Add(confirmTimeout)
+	pending.timer = time.AfterFunc(confirmTimeout, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if d.pending == pending {
+			log.Printf("The reload was not confirmed within %s", confirmTimeout)
+			d.rollbackLocked(pending)
+		}
+	})
+	d.pending = pending
+	d.status.ConfirmDeadline = &deadline
+	log.Printf("Waiting until %s for the reload to be confirmed", deadline.Format(time.RFC3339))
+
+	return nil
+}
+
+// Confirm keeps the rules applied by the reload waiting for confirmation
+func (d *daemon) Confirm(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.pending == nil {
+		return errors.New("no reload is waiting for confirmation")
+	}
+
+	d.pending.timer.Stop()
+	d.pending = nil
+	d.status.ConfirmDeadline = nil
+	log.Println("Reload confirmed")
+
+	return nil
+}
+
+// rollbackLocked restores the rules and the configuration in place before the reload
+func (d *daemon) rollbackLocked(pending *pendingReload) {
+	d.setConfiguration(pending.configuration)
+	d.applied = pending.applied
+	d.pending = nil
+	d.status.ConfirmDeadline = nil
+
+	err := d.firewall.Restore(pending.snapshot)
+	if err != nil {
+		log.Printf("Failed to restore the previous rules, applying the previous configuration: %v", err)
+		err = d.reconcileLocked(context.Background(), true)
+		if err != nil {
+			log.Printf("Failed to apply the previous configuration: %v", err)
+		}
+		return
+	}
+
+	log.Printf("Previous rules restored")
+}
+
+// files returns the patterns of the configuration files
+func (d *daemon) files() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.configuration.Files
+}
+
+// startWatching marks the configuration files as watched, before the API
+// accepts reloads waiting for confirmation
+func (d *daemon) startWatching() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.watching = true
+}
+
+// watch reloads the configuration whenever one of its files changes, until
+// the context is done. The watch is renewed when the included files change.
+func (d *daemon) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		files := d.files()
+		watchCtx, cancel := context.WithCancel(ctx)
+
+		err := config.Watch(watchCtx, files, func() {
+			log.Println("Configuration changed")
+			err := d.Reload(ctx, 0)
+			if err != nil {
+				log.Printf("Keeping the current rules: %v", err)
+				return
+			}
+
+			if !reflect.DeepEqual(files, d.files()) {
+				cancel()
+			}
+		})
+		cancel()
+
+		if err != nil {
+			log.Printf("Not watching the configuration files: %v", err)
+			return
+		}
+	}
+}
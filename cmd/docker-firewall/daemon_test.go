@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/firewall"
+	"github.com/albertogviana/docker-firewall/firewall/fake"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	confirmedConfig = `
+config:
+  rules:
+  - port: 22
+    protocol: tcp
+    allow:
+    - 10.0.0.0/8
+`
+	reloadedConfig = `
+config:
+  rules:
+  - port: 8080
+    protocol: tcp
+`
+)
+
+type DaemonTestSuite struct {
+	suite.Suite
+	directory string
+	backend   *fake.Backend
+	daemon    *daemon
+}
+
+func TestDaemonTestSuite(t *testing.T) {
+	suite.Run(t, new(DaemonTestSuite))
+}
+
+func (d *DaemonTestSuite) SetupTest() {
+	directory, err := ioutil.TempDir("", "docker-firewall")
+	d.Require().NoError(err)
+	d.directory = directory
+	configPath = directory
+
+	d.writeConfig(confirmedConfig)
+	configuration, err := config.NewConfiguration(directory)
+	d.Require().NoError(err)
+
+	d.backend = fake.New()
+	d.daemon = newDaemon(firewall.NewFirewallWithBackend(d.backend), configuration, nil)
+	d.Require().NoError(d.daemon.Apply(context.Background()))
+}
+
+func (d *DaemonTestSuite) TearDownTest() {
+	os.RemoveAll(d.directory)
+}
+
+func (d *DaemonTestSuite) writeConfig(content string) {
+	d.Require().NoError(ioutil.WriteFile(path.Join(d.directory, "config.yml"), []byte(content), 0644))
+}
+
+// firewallRules returns the DOCKER-FIREWALL rules in place
+func (d *DaemonTestSuite) firewallRules() [][]string {
+	return d.backend.Rules(firewall.FilterTable, firewall.FirewallChain)
+}
+
+// waitForRollback waits for the reload waiting for confirmation to expire
+func (d *DaemonTestSuite) waitForRollback() {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if d.daemon.Status().ConfirmDeadline == nil {
+			return
+		}
+	}
+
+	d.FailNow("the reload was not rolled back")
+}
+
+func (d *DaemonTestSuite) Test_Reload() {
+	confirmed := d.firewallRules()
+
+	d.writeConfig(reloadedConfig)
+	d.Require().NoError(d.daemon.Reload(context.Background(), 0))
+	d.NotEqual(confirmed, d.firewallRules())
+	d.Nil(d.daemon.Status().ConfirmDeadline)
+
+	d.writeConfig("config: [")
+	d.Error(d.daemon.Reload(context.Background(), 0))
+	d.Equal(8080, d.daemon.configuration.Config.Rules[0].Port)
+}
+
+func (d *DaemonTestSuite) Test_Confirm() {
+	d.writeConfig(reloadedConfig)
+	d.Require().NoError(d.daemon.Reload(context.Background(), time.Hour))
+	d.NotNil(d.daemon.Status().ConfirmDeadline)
+	reloaded := d.firewallRules()
+
+	d.Require().NoError(d.daemon.Confirm(context.Background()))
+	d.Nil(d.daemon.Status().ConfirmDeadline)
+	d.Equal(reloaded, d.firewallRules())
+
+	d.EqualError(d.daemon.Confirm(context.Background()), "no reload is waiting for confirmation")
+}
+
+func (d *DaemonTestSuite) Test_Rollback() {
+	confirmed := d.firewallRules()
+
+	d.writeConfig(reloadedConfig)
+	d.Require().NoError(d.daemon.Reload(context.Background(), 10*time.Millisecond))
+
+	d.waitForRollback()
+	d.Equal(confirmed, d.firewallRules())
+	d.Equal(22, d.daemon.configuration.Config.Rules[0].Port)
+	d.Error(d.daemon.Confirm(context.Background()))
+}
+
+func (d *DaemonTestSuite) Test_Rollback_RestoreFailed() {
+	d.writeConfig(confirmedConfig + "  ipset: true\n")
+	d.Require().NoError(d.daemon.Reload(context.Background(), 0))
+	confirmed := d.firewallRules()
+	confirmedSets := d.backend.Sets()
+
+	d.writeConfig(reloadedConfig)
+	d.Require().NoError(d.daemon.Reload(context.Background(), 10*time.Millisecond))
+	d.NotEqual(confirmed, d.firewallRules())
+
+	d.waitForRollback()
+	d.Equal(confirmed, d.firewallRules())
+	d.Equal(confirmedSets, d.backend.Sets())
+	d.True(d.daemon.configuration.Config.IPSet)
+}
+
+func (d *DaemonTestSuite) Test_Reload_WhilePending() {
+	confirmed := d.firewallRules()
+
+	d.writeConfig(reloadedConfig)
+	d.Require().NoError(d.daemon.Reload(context.Background(), time.Hour))
+
+	d.writeConfig(confirmedConfig)
+	d.Error(d.daemon.Reload(context.Background(), 0))
+	d.Error(d.daemon.Reload(context.Background(), time.Hour))
+	d.Equal(8080, d.daemon.configuration.Config.Rules[0].Port)
+
+	d.daemon.mu.Lock()
+	d.daemon.pending.timer.Stop()
+	d.daemon.rollbackLocked(d.daemon.pending)
+	d.daemon.mu.Unlock()
+	d.Equal(confirmed, d.firewallRules())
+}
+
+func (d *DaemonTestSuite) Test_Reload_Watching() {
+	d.daemon.startWatching()
+
+	d.writeConfig(reloadedConfig)
+	d.Error(d.daemon.Reload(context.Background(), time.Hour))
+	d.Nil(d.daemon.Status().ConfirmDeadline)
+	d.Equal(22, d.daemon.configuration.Config.Rules[0].Port)
+
+	d.NoError(d.daemon.Reload(context.Background(), 0))
+}
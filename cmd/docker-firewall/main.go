@@ -1,22 +1,50 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/albertogviana/docker-firewall/api"
+	"github.com/albertogviana/docker-firewall/compiler"
 	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/docker"
 	"github.com/albertogviana/docker-firewall/firewall"
+	"github.com/albertogviana/docker-firewall/lint"
+	"github.com/albertogviana/docker-firewall/metrics"
+	"github.com/albertogviana/docker-firewall/reconciler"
 	"github.com/urfave/cli"
 )
 
 var pidFile = "/tmp/docker-firewall"
 var configPath = "/etc/docker-firewall"
+var backend string
+var interval time.Duration
+var dockerSocket string
+var labels bool
+var swarm bool
+var apiSocket string
+var apiListen string
+var apiTokenFile string
+var watch bool
+var confirmTimeout time.Duration
+var lintInterfaces bool
+var packet = struct {
+	source   string
+	protocol string
+	port     int
+	iface    string
+}{}
 
 var (
 	version   string
@@ -44,18 +72,162 @@ func main() {
 	app.Name = "docker-firewall"
 	app.Usage = "Easy way to apply firewall rules to block docker services."
 	app.Version = version
+	backendFlag := cli.StringFlag{
+		Name:        "backend",
+		Usage:       "firewall backend, iptables or nftables (default from the configuration file)",
+		Destination: &backend,
+	}
+	socketFlag := cli.StringFlag{
+		Name:        "socket",
+		Usage:       "unix socket of the control API",
+		Value:       api.DefaultSocket,
+		Destination: &apiSocket,
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:  "start",
 			Usage: "start the service",
+			Flags: []cli.Flag{
+				backendFlag,
+				cli.DurationFlag{
+					Name:        "interval",
+					Usage:       "interval between two verifications of the rules",
+					Value:       reconciler.DefaultOptions.Interval,
+					Destination: &interval,
+				},
+				cli.StringFlag{
+					Name:        "docker-socket",
+					Usage:       "dockerd socket to follow the events from, empty to disable",
+					Value:       docker.DefaultSocket,
+					Destination: &dockerSocket,
+				},
+				cli.BoolFlag{
+					Name:        "labels",
+					Usage:       "add the rules declared with the " + docker.AllowLabel + " label of the running containers",
+					Destination: &labels,
+				},
+				cli.BoolFlag{
+					Name:        "swarm",
+					Usage:       "match the original destination port of the ports published through the Swarm routing mesh",
+					Destination: &swarm,
+				},
+				cli.StringFlag{
+					Name:        "socket",
+					Usage:       "unix socket of the control API, empty to disable",
+					Value:       api.DefaultSocket,
+					Destination: &apiSocket,
+				},
+				cli.StringFlag{
+					Name:        "listen",
+					Usage:       "TCP address the control API also listens on, such as 127.0.0.1:9760, requires --listen-token-file",
+					Destination: &apiListen,
+				},
+				cli.StringFlag{
+					Name:        "listen-token-file",
+					Usage:       "file holding the token the requests to the TCP control API must send as a bearer Authorization header",
+					Destination: &apiTokenFile,
+				},
+				cli.BoolTFlag{
+					Name:        "watch",
+					Usage:       "reload the configuration when its files change, disable with --watch=false",
+					Destination: &watch,
+				},
+			},
 			Action: func(c *cli.Context) error {
 				start()
 				return nil
 			},
 		},
+		{
+			Name:      "validate",
+			Usage:     "validate the configuration file",
+			ArgsUsage: "[config directory]",
+			Action: func(c *cli.Context) error {
+				return validate(c.Args().First())
+			},
+		},
+		{
+			Name:      "lint",
+			Usage:     "report the shadowed, redundant and contradictory rules, exits with 2 when there are findings",
+			ArgsUsage: "[config directory]",
+			Flags: []cli.Flag{
+				cli.BoolTFlag{
+					Name:        "interfaces",
+					Usage:       "report the interfaces missing on this host, disable with --interfaces=false",
+					Destination: &lintInterfaces,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return lintRules(c.Args().First())
+			},
+		},
+		{
+			Name:      "check",
+			Usage:     "tell how the configured rules decide a new connection, exits with 2 when it is dropped or rejected",
+			ArgsUsage: "[config directory]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "src",
+					Usage:       "source IP of the connection",
+					Destination: &packet.source,
+				},
+				cli.StringFlag{
+					Name:        "proto",
+					Usage:       "protocol of the connection",
+					Value:       "tcp",
+					Destination: &packet.protocol,
+				},
+				cli.IntFlag{
+					Name:        "dport",
+					Usage:       "destination port of the connection",
+					Destination: &packet.port,
+				},
+				cli.StringFlag{
+					Name:        "iface",
+					Usage:       "interface the connection comes in from",
+					Destination: &packet.iface,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return check(c.Args().First())
+			},
+		},
+		{
+			Name:  "plan",
+			Usage: "show the changes apply would make, exits with 2 when there are changes",
+			Flags: []cli.Flag{backendFlag},
+			Action: func(c *cli.Context) error {
+				return plan()
+			},
+		},
+		{
+			Name:  "apply",
+			Usage: "make the running service read the configuration again and apply it",
+			Flags: []cli.Flag{
+				socketFlag,
+				cli.DurationFlag{
+					Name:        "confirm-timeout",
+					Usage:       "restore the previous rules unless confirmed within this duration, requires the service to run with --watch=false",
+					Destination: &confirmTimeout,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return apply()
+			},
+		},
+		{
+			Name:  "confirm",
+			Usage: "keep the rules applied with apply --confirm-timeout",
+			Flags: []cli.Flag{socketFlag},
+			Action: func(c *cli.Context) error {
+				return confirm()
+			},
+		},
 		{
 			Name:  "stop",
 			Usage: "stop the service",
+			Flags: []cli.Flag{backendFlag},
 			Action: func(c *cli.Context) error {
 				stop()
 				return nil
@@ -71,18 +243,29 @@ func main() {
 
 func start() {
 	log.Println("Starting docker-firewall")
-	config, err := config.NewConfiguration(configPath)
+	configuration, err := config.NewConfiguration(configPath)
 	if err != nil {
 		log.Fatalf("failed to read the configuration file: %v", err)
 	}
 
-	firewall, err := firewall.NewFirewall()
+	if backend == "" {
+		backend = configuration.Config.Backend
+	}
+
+	firewall, err := firewall.New(backend)
 	if err != nil {
 		log.Fatalf("failed to start firewall: %v", err)
 	}
 
+	var client *docker.Client
+	if dockerSocket != "" {
+		client = docker.NewClient(dockerSocket)
+	}
+
+	d := newDaemon(firewall, configuration, client)
+
 	log.Println("Applying rules")
-	err = firewall.Apply(config.Config.Rules)
+	err = d.Apply(context.Background())
 	if err != nil {
 		stop()
 		log.Fatalf("it was not possible to apply the rules: %v", err)
@@ -95,9 +278,22 @@ func start() {
 		log.Fatalf("failed to create pid file with error: %v", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGQUIT)
-	exitChan := make(chan int)
+	exitChan := make(chan int, 1)
+	runDone := make(chan struct{})
+
+	// shutdown stops the reconciler before clearing the rules, so they are
+	// not applied again once cleared
+	shutdown := func(code int) {
+		cancel()
+		<-runDone
+		stop()
+		exitChan <- code
+	}
 
 	go func() {
 		for {
@@ -108,50 +304,131 @@ func start() {
 			// kill -SIGHUP XXXX
 			case syscall.SIGHUP:
 				log.Println("Reloading configuration")
-				stop()
-				start()
+				err := d.Reload(ctx, 0)
+				if err != nil {
+					log.Printf("Keeping the current rules: %v", err)
+				}
 
 			// kill -SIGTERM XXXX
 			case syscall.SIGTERM:
 				log.Println("stop and core dump")
-				stop()
-				exitChan <- 0
+				shutdown(0)
+				return
 
 			// kill -SIGQUIT XXXX
 			case syscall.SIGQUIT:
 				log.Println("Stopping the service")
-				stop()
-				exitChan <- 0
+				shutdown(0)
+				return
 
 			default:
 				log.Println("Unknown signal.")
-				stop()
-				exitChan <- 1
+				shutdown(1)
+				return
 			}
 		}
 	}()
 
-	for {
-		time.Sleep(10 * time.Second)
-		verify, err := firewall.Verify(config.Config.Rules)
+	rec := reconciler.New(d.reconcile, reconciler.Options{Interval: interval})
+
+	if watch {
+		d.startWatching()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", api.NewHandler(d))
+	mux.Handle("/metrics", metrics.Handler(d.metrics, d.Counters))
+
+	for _, address := range apiAddresses() {
+		var handler http.Handler = mux
+		if address[0] == "tcp" {
+			token, err := apiToken()
+			if err != nil {
+				stop()
+				log.Fatalf("the control API can not listen on %s: %v", address[1], err)
+			}
+
+			log.Printf("WARNING: the control API listens on %s over plain HTTP, anyone able to read the traffic can take the token and change the firewall rules. Prefer the unix socket, or only listen on a trusted interface.", address[1])
+			handler = api.RequireToken(token, mux)
+		}
+
+		listener, err := api.Listen(address[0], address[1])
 		if err != nil {
-			log.Printf("Something went wrong: %s", err)
 			stop()
-			exitChan <- 1
+			log.Fatalf("failed to listen on %s: %v", address[1], err)
 		}
 
-		if !verify {
-			log.Println("Applying rules again.")
-			firewall.Apply(config.Config.Rules)
+		go func(listener net.Listener, address string) {
+			err := api.Serve(ctx, listener, handler)
+			if err != nil {
+				log.Printf("The API on %s stopped: %v", address, err)
+			}
+		}(listener, address[1])
+	}
+
+	go func() {
+		err := reconciler.WatchNetfilter(ctx, rec.Trigger)
+		if err != nil {
+			log.Printf("Not watching netfilter changes, relying on the %s interval: %v", interval, err)
 		}
+	}()
+
+	if client != nil {
+		go client.Watch(ctx, rec.Force)
+	}
+
+	if watch {
+		go d.watch(ctx)
 	}
 
+	rec.Run(ctx)
+	close(runDone)
+
 	code := <-exitChan
 	os.Exit(code)
 }
 
+// apiToken reads the token required by the TCP control API
+func apiToken() (string, error) {
+	if apiTokenFile == "" {
+		return "", errors.New("--listen-token-file is required, since the API can change the firewall rules")
+	}
+
+	content, err := ioutil.ReadFile(apiTokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("the token file %s is empty", apiTokenFile)
+	}
+
+	return token, nil
+}
+
+// apiAddresses returns the network and address pairs the API listens on
+func apiAddresses() [][2]string {
+	addresses := [][2]string{}
+
+	if apiSocket != "" {
+		addresses = append(addresses, [2]string{"unix", apiSocket})
+	}
+
+	if apiListen != "" {
+		addresses = append(addresses, [2]string{"tcp", apiListen})
+	}
+
+	return addresses
+}
+
 func stop() {
-	firewall, err := firewall.NewFirewall()
+	configuration, err := config.NewConfiguration(configPath)
+	if backend == "" && err == nil {
+		backend = configuration.Config.Backend
+	}
+
+	firewall, err := firewall.New(backend)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -178,6 +455,205 @@ func stop() {
 	}
 }
 
+func validate(directory string) error {
+	if directory == "" {
+		directory = configPath
+	}
+
+	err := config.Validate(directory)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	fmt.Printf("%s/config.yml is valid\n", directory)
+
+	return nil
+}
+
+func lintRules(directory string) error {
+	if directory == "" {
+		directory = configPath
+	}
+
+	configuration, err := config.NewConfiguration(directory)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to read the configuration file: %v", err), 1)
+	}
+
+	var interfaces []string
+	if lintInterfaces {
+		hostInterfaces, err := net.Interfaces()
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("failed to list the interfaces: %v", err), 1)
+		}
+
+		interfaces = []string{}
+		for _, iface := range hostInterfaces {
+			interfaces = append(interfaces, iface.Name)
+		}
+	}
+
+	findings := lint.Lint(configuration.Config.Rules, []compiler.Family{compiler.IPv4, compiler.IPv6}, interfaces)
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+
+	switch len(findings) {
+	case 0:
+	case 1:
+		return cli.NewExitError("1 finding", 2)
+	default:
+		return cli.NewExitError(fmt.Sprintf("%d findings", len(findings)), 2)
+	}
+
+	fmt.Printf("%s/config.yml has no findings\n", directory)
+
+	return nil
+}
+
+func check(directory string) error {
+	if directory == "" {
+		directory = configPath
+	}
+
+	source := net.ParseIP(packet.source)
+	if source == nil {
+		return cli.NewExitError(fmt.Sprintf("invalid source IP %q", packet.source), 1)
+	}
+
+	if packet.port < 0 || packet.port > 65535 {
+		return cli.NewExitError(fmt.Sprintf("invalid destination port %d", packet.port), 1)
+	}
+
+	configuration, err := config.NewConfiguration(directory)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to read the configuration file: %v", err), 1)
+	}
+
+	simulated := compiler.Packet{Source: source, Interface: packet.iface, Protocol: packet.protocol, Port: packet.port}
+	policy := compiler.Policy{Default: configuration.Config.DefaultPolicy, Log: configuration.Config.LogDefault}
+	chain := compiler.Chain(configuration.Config.Rules, simulated.Family(), policy)
+
+	decision, ok := compiler.Evaluate(chain, simulated)
+	if !ok {
+		return cli.NewExitError("no rule decided the connection", 1)
+	}
+
+	for _, rule := range decision.Logged {
+		fmt.Printf("logged by %s: %s\n", ruleOrigin(configuration.Config.Rules, rule.Origin), rule)
+	}
+	fmt.Printf("decided by %s: %s\n", ruleOrigin(configuration.Config.Rules, decision.Rule.Origin), decision.Rule)
+
+	switch decision.Rule.Action.Verdict {
+	case config.ActionDrop:
+		return cli.NewExitError("the connection is dropped", 2)
+	case config.ActionReject:
+		return cli.NewExitError("the connection is rejected", 2)
+	case config.ActionAccept:
+		fmt.Println("the connection is allowed")
+	default:
+		fmt.Println("the connection is allowed through to the Docker rules")
+	}
+
+	return nil
+}
+
+// ruleOrigin describes the rule a compiled rule comes from
+func ruleOrigin(rules []config.Rule, origin int) string {
+	switch {
+	case origin == compiler.DefaultOrigin:
+		return "the default policy"
+	case origin == compiler.DefaultLogOrigin:
+		return "log_default"
+	case origin == compiler.EstablishedOrigin:
+		return "the established connections rule"
+	case rules[origin].Name != "":
+		return fmt.Sprintf("rule %d (%s)", origin, rules[origin].Name)
+	default:
+		return fmt.Sprintf("rule %d", origin)
+	}
+}
+
+func plan() error {
+	configuration, err := config.NewConfiguration(configPath)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to read the configuration file: %v", err), 1)
+	}
+
+	if backend == "" {
+		backend = configuration.Config.Backend
+	}
+
+	fw, err := firewall.New(backend)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to start firewall: %v", err), 1)
+	}
+	fw.SetDefaultPolicy(configuration.Config.DefaultPolicy, configuration.Config.LogDefault)
+	fw.SetOptimize(configuration.Config.Optimize)
+	fw.SetIPSet(configuration.Config.IPSet)
+
+	plans, err := fw.Plan(configuration.Config.Rules)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to plan the rules: %v", err), 1)
+	}
+
+	reports := fw.Optimization(configuration.Config.Rules)
+
+	drift := false
+	for _, plan := range plans {
+		fmt.Printf("--- %s applied\n", plan.Family)
+		fmt.Printf("+++ %s planned\n", plan.Family)
+		for _, change := range plan.Changes {
+			fmt.Printf("%s %s\n", change.Type, change.Rule)
+		}
+
+		added, removed := plan.Counts()
+		if configuration.Config.Optimize {
+			fmt.Printf("%s: %s.\n", plan.Family, reports[plan.Family])
+		}
+		fmt.Printf("%s: %d to add, %d to remove.\n\n", plan.Family, added, removed)
+
+		drift = drift || plan.HasDrift()
+	}
+
+	if drift {
+		return cli.NewExitError("", 2)
+	}
+
+	return nil
+}
+
+func apply() error {
+	client := api.NewClient("unix", apiSocket)
+
+	status, err := client.Reload(context.Background(), confirmTimeout)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to apply the rules: %v", err), 1)
+	}
+
+	if status.ConfirmDeadline != nil {
+		fmt.Printf("Rules applied, run docker-firewall confirm before %s or they are rolled back.\n", status.ConfirmDeadline.Format(time.RFC3339))
+		return nil
+	}
+
+	fmt.Println("Rules applied.")
+
+	return nil
+}
+
+func confirm() error {
+	client := api.NewClient("unix", apiSocket)
+
+	err := client.Confirm(context.Background())
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("failed to confirm the rules: %v", err), 1)
+	}
+
+	fmt.Println("Rules confirmed.")
+
+	return nil
+}
+
 func writePidFile() error {
 	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
 		piddata, err := ioutil.ReadFile(pidFile)
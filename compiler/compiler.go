@@ -0,0 +1,360 @@
+// Package compiler lowers the configuration rules into a typed intermediate
+// representation, the rules of a chain as a match set and an action, which
+// is rendered for a firewall backend separately
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Family is the IP protocol rules are compiled for
+type Family int
+
+const (
+	// IPv4 rules are programmed with iptables
+	IPv4 Family = iota
+	// IPv6 rules are programmed with ip6tables
+	IPv6
+)
+
+func (f Family) String() string {
+	if f == IPv6 {
+		return "IPv6"
+	}
+
+	return "IPv4"
+}
+
+// Origins of the rules added by docker-firewall around the configuration rules
+const (
+	// EstablishedOrigin is the origin of the rule letting the replies of
+	// allowed connections through
+	EstablishedOrigin = -1
+	// DefaultOrigin is the origin of the rule applying the default policy
+	DefaultOrigin = -2
+	// DefaultLogOrigin is the origin of the rule logging the traffic the
+	// default policy applies to
+	DefaultLogOrigin = -3
+)
+
+// DefaultLogPrefix is the prefix of the traffic logged before the default policy
+const DefaultLogPrefix = "docker-firewall: "
+
+// DefaultLogLimit is the rate the traffic reaching the default policy is logged at
+const DefaultLogLimit = "5/min"
+
+// defaultLogLevel is the level logs are written with when none is set
+const defaultLogLevel = 4
+
+// Match defines the packets a rule matches, an empty field matches anything
+type Match struct {
+	// Source is the source address, network or range
+	Source *config.Address
+	// Set is the set of sources, used instead of Source
+	Set *Set
+	// Exclude are the sources never matched, whatever Source or Set match
+	Exclude []config.Address
+	// Interface is the input interface, a trailing + matches a prefix
+	Interface string
+	// Protocol is the layer 4 protocol
+	Protocol string
+	// Ports are the destination ports, they require a Protocol
+	Ports []config.PortRange
+	// OriginalPort matches the Ports before destination NAT
+	OriginalPort bool
+	// State are the conntrack states
+	State []string
+	// Limit is the rate the rule matches at, such as 5/min
+	Limit string
+}
+
+// Action defines what happens to the packets a rule matches
+type Action struct {
+	// Verdict is one of the config.Action values
+	Verdict string
+	// RejectWith is the error a reject answers with, for the compiled family
+	RejectWith string
+	// LogPrefix and LogLevel are the options of a log
+	LogPrefix string
+	LogLevel  int
+}
+
+// Rule is a compiled rule. Origin is the index of the configuration rule it
+// was compiled from, or one of the origins of the rules docker-firewall adds.
+type Rule struct {
+	Match  Match
+	Action Action
+	Origin int
+}
+
+// Policy defines what happens to the traffic no rule matched
+type Policy struct {
+	// Default is one of the config.Policy values, drop when empty
+	Default string
+	// Log logs the traffic before applying the policy
+	Log bool
+}
+
+// Chain compiles the whole DOCKER-FIREWALL chain of a family: the replies of
+// allowed connections, the configuration rules in order, then the policy
+func Chain(rules []config.Rule, family Family, policy Policy) []Rule {
+	chain := []Rule{{
+		Match:  Match{State: []string{"RELATED", "ESTABLISHED"}},
+		Action: Action{Verdict: config.ActionReturn},
+		Origin: EstablishedOrigin,
+	}}
+
+	for i, rule := range rules {
+		chain = append(chain, Compile(rule, i, family)...)
+	}
+
+	if policy.Log {
+		chain = append(chain, Rule{
+			Match:  Match{Limit: DefaultLogLimit},
+			Action: Action{Verdict: config.ActionLog, LogPrefix: DefaultLogPrefix, LogLevel: defaultLogLevel},
+			Origin: DefaultLogOrigin,
+		})
+	}
+
+	return append(chain, Rule{Action: policyAction(policy.Default, family), Origin: DefaultOrigin})
+}
+
+// Compile lowers a configuration rule into the rules of a family. Allow
+// entries are only compiled in their own family, rules without a source are
+// compiled in both. Negated entries are excluded from every rule of their
+// family, and a rule with negated entries only matches any other source.
+// The rules are the product of the sources, interfaces and protocols, where
+// rules with ports and without protocol match tcp and udp, as do rules with
+// neither protocol nor interface.
+func Compile(rule config.Rule, origin int, family Family) []Rule {
+	compiled := []Rule{}
+
+	if !protocolInFamily(rule.Protocol, family) {
+		return compiled
+	}
+
+	sources := []*config.Address{}
+	excluded := []config.Address{}
+	positive := false
+	for _, entry := range rule.Allow {
+		address, err := config.ParseAddress(entry)
+		if err != nil {
+			continue
+		}
+
+		positive = positive || !address.Negate
+		if address.IsIPv6() != (family == IPv6) {
+			continue
+		}
+
+		if address.Negate {
+			address.Negate = false
+			excluded = append(excluded, address)
+			continue
+		}
+
+		sources = append(sources, &address)
+	}
+
+	if !positive {
+		sources = append(sources, nil)
+	}
+
+	if len(excluded) == 0 {
+		excluded = nil
+	}
+
+	interfaces := rule.Interface
+	if len(interfaces) == 0 {
+		interfaces = []string{""}
+	}
+
+	ports := rule.PortRanges()
+
+	protocols := []string{rule.Protocol}
+	if rule.Protocol == "" && (len(ports) > 0 || len(rule.Interface) == 0) {
+		protocols = []string{"tcp", "udp"}
+	}
+
+	action := compileAction(rule, family)
+
+	for _, source := range sources {
+		for _, iface := range interfaces {
+			for _, protocol := range protocols {
+				match := Match{Source: source, Exclude: excluded, Interface: iface, Protocol: protocol}
+				if protocol != "" {
+					match.Ports = ports
+					match.OriginalPort = rule.OriginalPort && len(ports) > 0
+				}
+
+				compiled = append(compiled, Rule{Match: match, Action: action, Origin: origin})
+			}
+		}
+	}
+
+	return compiled
+}
+
+func compileAction(rule config.Rule, family Family) Action {
+	switch rule.Action {
+	case config.ActionAccept, config.ActionDrop:
+		return Action{Verdict: rule.Action}
+	case config.ActionReject:
+		return Action{Verdict: config.ActionReject, RejectWith: RejectType(rule.RejectWith, family)}
+	case config.ActionLog:
+		level := defaultLogLevel
+		if rule.LogLevel != "" {
+			if number, err := config.LogLevel(rule.LogLevel); err == nil {
+				level = number
+			}
+		}
+		return Action{Verdict: config.ActionLog, LogPrefix: rule.LogPrefix, LogLevel: level}
+	default:
+		return Action{Verdict: config.ActionReturn}
+	}
+}
+
+func policyAction(policy string, family Family) Action {
+	switch policy {
+	case config.PolicyReturn:
+		return Action{Verdict: config.ActionReturn}
+	case config.PolicyReject:
+		return Action{Verdict: config.ActionReject, RejectWith: RejectType("", family)}
+	default:
+		return Action{Verdict: config.ActionDrop}
+	}
+}
+
+// ipv6RejectTypes translates the iptables reject types to ip6tables
+var ipv6RejectTypes = map[string]string{
+	"icmp-net-unreachable":   "icmp6-no-route",
+	"icmp-host-unreachable":  "icmp6-addr-unreachable",
+	"icmp-port-unreachable":  "icmp6-port-unreachable",
+	"icmp-proto-unreachable": "icmp6-port-unreachable",
+	"icmp-net-prohibited":    "icmp6-adm-prohibited",
+	"icmp-host-prohibited":   "icmp6-adm-prohibited",
+	"icmp-admin-prohibited":  "icmp6-adm-prohibited",
+}
+
+// ipv4RejectTypes translates the ip6tables reject types to iptables
+var ipv4RejectTypes = map[string]string{
+	"icmp6-no-route":         "icmp-net-unreachable",
+	"icmp6-addr-unreachable": "icmp-host-unreachable",
+	"icmp6-port-unreachable": "icmp-port-unreachable",
+	"icmp6-adm-prohibited":   "icmp-admin-prohibited",
+}
+
+// RejectType returns the reject type in the given family, defaulting to
+// port unreachable
+func RejectType(name string, family Family) string {
+	if name == "" {
+		name = "icmp-port-unreachable"
+	}
+
+	translations := ipv4RejectTypes
+	if family == IPv6 {
+		translations = ipv6RejectTypes
+	}
+
+	if translated, ok := translations[name]; ok {
+		return translated
+	}
+
+	return name
+}
+
+// protocolInFamily reports if a layer 4 protocol can be matched in the family
+func protocolInFamily(protocol string, family Family) bool {
+	switch protocol {
+	case "icmp":
+		return family == IPv4
+	case "icmpv6", "ipv6-icmp":
+		return family == IPv6
+	default:
+		return true
+	}
+}
+
+// String prints the match, "all" when it matches every packet
+func (m Match) String() string {
+	parts := []string{}
+
+	if m.Source != nil {
+		parts = append(parts, "from "+m.Source.String())
+	}
+
+	if m.Set != nil {
+		parts = append(parts, "from set "+m.Set.Name)
+	}
+
+	if len(m.Exclude) > 0 {
+		excluded := []string{}
+		for _, address := range m.Exclude {
+			excluded = append(excluded, address.String())
+		}
+		parts = append(parts, "except "+strings.Join(excluded, ","))
+	}
+
+	if m.Interface != "" {
+		parts = append(parts, "in "+m.Interface)
+	}
+
+	if m.Protocol != "" {
+		parts = append(parts, m.Protocol)
+	}
+
+	if len(m.Ports) > 0 {
+		ports := []string{}
+		for _, port := range m.Ports {
+			ports = append(ports, port.String())
+		}
+
+		keyword := "dport"
+		if m.OriginalPort {
+			keyword = "original dport"
+		}
+		parts = append(parts, keyword+" "+strings.Join(ports, ","))
+	}
+
+	if len(m.State) > 0 {
+		parts = append(parts, "state "+strings.ToLower(strings.Join(m.State, ",")))
+	}
+
+	if m.Limit != "" {
+		parts = append(parts, "limit "+m.Limit)
+	}
+
+	if len(parts) == 0 {
+		return "all"
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// String prints the action
+func (a Action) String() string {
+	switch a.Verdict {
+	case config.ActionReject:
+		return "reject with " + a.RejectWith
+	case config.ActionLog:
+		log := "log"
+		if a.LogPrefix != "" {
+			log += " prefix " + strconv.Quote(a.LogPrefix)
+		}
+		if a.LogLevel != defaultLogLevel {
+			log += fmt.Sprintf(" level %d", a.LogLevel)
+		}
+		return log
+	default:
+		return a.Verdict
+	}
+}
+
+// String prints the rule as its match and action
+func (r Rule) String() string {
+	return r.Match.String() + " -> " + r.Action.String()
+}
@@ -0,0 +1,95 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type CompilerTestSuite struct {
+	suite.Suite
+}
+
+func TestCompilerTestSuite(t *testing.T) {
+	suite.Run(t, new(CompilerTestSuite))
+}
+
+func (c *CompilerTestSuite) strings(rules []Rule) []string {
+	printed := []string{}
+	for _, rule := range rules {
+		printed = append(printed, rule.String())
+	}
+
+	return printed
+}
+
+func (c *CompilerTestSuite) Test_Compile() {
+	rule := config.Rule{
+		Interface: []string{"eth0"},
+		Protocol:  "tcp",
+		Ports:     []config.PortRange{{From: 80, To: 80}, {From: 8000, To: 8100}},
+		Allow:     []string{"10.0.0.0/8", "192.168.1.0/24", "!10.0.0.1", "2001:db8::/32"},
+		Action:    config.ActionReject,
+	}
+
+	compiled := Compile(rule, 3, IPv4)
+	c.Equal([]string{
+		"from 10.0.0.0/8 except 10.0.0.1 in eth0 tcp dport 80,8000-8100 -> reject with icmp-port-unreachable",
+		"from 192.168.1.0/24 except 10.0.0.1 in eth0 tcp dport 80,8000-8100 -> reject with icmp-port-unreachable",
+	}, c.strings(compiled))
+	c.Equal(3, compiled[0].Origin)
+
+	c.Equal([]string{
+		"from 2001:db8::/32 in eth0 tcp dport 80,8000-8100 -> reject with icmp6-port-unreachable",
+	}, c.strings(Compile(rule, 3, IPv6)))
+}
+
+func (c *CompilerTestSuite) Test_Compile_Protocols() {
+	var tests = []struct {
+		rule     config.Rule
+		family   Family
+		expected []string
+	}{
+		{config.Rule{Port: 3000}, IPv4, []string{"tcp dport 3000 -> return", "udp dport 3000 -> return"}},
+		{config.Rule{Interface: []string{"docker0"}}, IPv4, []string{"in docker0 -> return"}},
+		{config.Rule{Protocol: "icmp", Action: config.ActionAccept}, IPv4, []string{"icmp -> accept"}},
+		{config.Rule{Protocol: "icmp"}, IPv6, []string{}},
+		{config.Rule{Allow: []string{"10.0.0.1"}}, IPv6, []string{}},
+		{config.Rule{Allow: []string{"10.0.0.1", "!2001:db8::1"}}, IPv6, []string{}},
+		{config.Rule{Protocol: "tcp", Allow: []string{"!10.0.0.0/8", "!2001:db8::1"}}, IPv4, []string{"except 10.0.0.0/8 tcp -> return"}},
+		{config.Rule{Protocol: "tcp", Allow: []string{"!10.0.0.0/8"}}, IPv6, []string{"tcp -> return"}},
+		{config.Rule{Protocol: "tcp", Port: 3000, OriginalPort: true}, IPv4, []string{"tcp original dport 3000 -> return"}},
+		{config.Rule{Port: 22, Action: config.ActionLog, LogPrefix: "ssh: ", LogLevel: "debug"}, IPv4, []string{
+			`tcp dport 22 -> log prefix "ssh: " level 7`,
+			`udp dport 22 -> log prefix "ssh: " level 7`,
+		}},
+	}
+
+	for _, test := range tests {
+		c.Equal(test.expected, c.strings(Compile(test.rule, 0, test.family)))
+	}
+}
+
+func (c *CompilerTestSuite) Test_Chain() {
+	rules := []config.Rule{{Protocol: "tcp", Port: 443}}
+
+	chain := Chain(rules, IPv6, Policy{Default: config.PolicyReject, Log: true})
+	c.Equal([]string{
+		"state related,established -> return",
+		"tcp dport 443 -> return",
+		`limit 5/min -> log prefix "docker-firewall: "`,
+		"all -> reject with icmp6-port-unreachable",
+	}, c.strings(chain))
+	c.Equal([]int{EstablishedOrigin, 0, DefaultLogOrigin, DefaultOrigin}, []int{chain[0].Origin, chain[1].Origin, chain[2].Origin, chain[3].Origin})
+
+	chain = Chain(nil, IPv4, Policy{})
+	c.Equal([]string{"state related,established -> return", "all -> drop"}, c.strings(chain))
+}
+
+func (c *CompilerTestSuite) Test_RejectType() {
+	c.Equal("icmp-port-unreachable", RejectType("", IPv4))
+	c.Equal("icmp6-adm-prohibited", RejectType("icmp-host-prohibited", IPv6))
+	c.Equal("icmp-admin-prohibited", RejectType("icmp6-adm-prohibited", IPv4))
+	c.Equal("tcp-reset", RejectType("tcp-reset", IPv6))
+}
@@ -0,0 +1,185 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// matchModules are the iptables match modules of the protocols, the others
+// are matched with -p only, and their ports with multiport
+var matchModules = map[string]string{
+	"tcp":       "tcp",
+	"udp":       "udp",
+	"sctp":      "sctp",
+	"dccp":      "dccp",
+	"icmp":      "icmp",
+	"icmpv6":    "icmp6",
+	"ipv6-icmp": "icmp6",
+	"esp":       "esp",
+	"ah":        "ah",
+}
+
+// multiportMaxPorts is the maximum number of ports in a multiport match,
+// where a port range counts as two
+const multiportMaxPorts = 15
+
+// IPTables renders a rule as iptables arguments. A rule renders to several
+// when its ports do not fit a single multiport match, or match the original
+// ports, which conntrack can only do one port at a time.
+func IPTables(rule Rule) [][]string {
+	base := []string{}
+
+	if rule.Match.Source != nil {
+		base = append(base, sourceMatch(*rule.Match.Source)...)
+	}
+
+	if rule.Match.Set != nil {
+		base = append(base, "-m", "set", "--match-set", rule.Match.Set.Name, "src")
+	}
+
+	for _, address := range rule.Match.Exclude {
+		start, end := addressBounds(address)
+		base = append(base, "-m", "iprange", "!", "--src-range", start.String()+"-"+end.String())
+	}
+
+	if rule.Match.Interface != "" {
+		base = append(base, "-i", rule.Match.Interface)
+	}
+
+	if len(rule.Match.State) > 0 {
+		base = append(base, "-m", "conntrack", "--ctstate", strings.Join(rule.Match.State, ","))
+	}
+
+	if rule.Match.Limit != "" {
+		base = append(base, "-m", "limit", "--limit", rule.Match.Limit)
+	}
+
+	target := iptablesTarget(rule.Action)
+
+	rendered := [][]string{}
+	for _, match := range protocolMatches(rule.Match.Protocol, rule.Match.Ports, rule.Match.OriginalPort) {
+		argv := append([]string{}, base...)
+		argv = append(argv, match...)
+		argv = append(argv, target...)
+		rendered = append(rendered, argv)
+	}
+
+	return rendered
+}
+
+// iptablesTarget renders the target of an action the way iptables lists it,
+// the reject type is always set and the default log level never is
+func iptablesTarget(action Action) []string {
+	switch action.Verdict {
+	case config.ActionAccept:
+		return []string{"-j", "ACCEPT"}
+	case config.ActionDrop:
+		return []string{"-j", "DROP"}
+	case config.ActionReject:
+		return []string{"-j", "REJECT", "--reject-with", action.RejectWith}
+	case config.ActionLog:
+		target := []string{"-j", "LOG"}
+		if action.LogPrefix != "" {
+			target = append(target, "--log-prefix", action.LogPrefix)
+		}
+		if action.LogLevel != defaultLogLevel {
+			target = append(target, "--log-level", strconv.Itoa(action.LogLevel))
+		}
+		return target
+	default:
+		return []string{"-j", "RETURN"}
+	}
+}
+
+// protocolMatches renders the protocol and destination port matches, using
+// multiport when there is more than one port. Original ports are matched
+// with conntrack, one rule per port.
+func protocolMatches(protocol string, ports []config.PortRange, original bool) [][]string {
+	if protocol == "" {
+		return [][]string{{}}
+	}
+
+	module, ok := matchModules[protocol]
+
+	matches := [][]string{}
+	for _, group := range portGroups(ports, original) {
+		match := []string{"-p", protocol}
+
+		switch {
+		case original && len(group) > 0:
+			match = append(match, "-m", "conntrack", "--ctorigdstport", iptablesPort(group[0]))
+		case len(group) == 0 && ok:
+			match = append(match, "-m", module)
+		case len(group) == 0:
+		case len(ports) == 1 && ok:
+			match = append(match, "-m", module, "--dport", iptablesPort(group[0]))
+		default:
+			dports := []string{}
+			for _, port := range group {
+				dports = append(dports, iptablesPort(port))
+			}
+			match = append(match, "-m", "multiport", "--dports", strings.Join(dports, ","))
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// portGroups groups the ports matched by a single rule: original ports one
+// by one, and the others in groups fitting a multiport match. There is a
+// single empty group without ports.
+func portGroups(ports []config.PortRange, original bool) [][]config.PortRange {
+	if len(ports) == 0 {
+		return [][]config.PortRange{nil}
+	}
+
+	groups := [][]config.PortRange{}
+	if original {
+		for _, port := range ports {
+			groups = append(groups, []config.PortRange{port})
+		}
+		return groups
+	}
+
+	group := []config.PortRange{}
+	slots := 0
+	for _, port := range ports {
+		size := 1
+		if port.IsRange() {
+			size = 2
+		}
+
+		if slots+size > multiportMaxPorts {
+			groups = append(groups, group)
+			group = []config.PortRange{}
+			slots = 0
+		}
+
+		group = append(group, port)
+		slots += size
+	}
+
+	return append(groups, group)
+}
+
+func iptablesPort(port config.PortRange) string {
+	if port.IsRange() {
+		return fmt.Sprintf("%d:%d", port.From, port.To)
+	}
+
+	return strconv.Itoa(port.From)
+}
+
+// sourceMatch renders the iptables match for a source address
+func sourceMatch(address config.Address) []string {
+	if address.IsRange() {
+		return []string{"-m", "iprange", "--src-range", address.Value}
+	}
+
+	return []string{"-s", address.Value}
+}
@@ -0,0 +1,117 @@
+package compiler
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type IPTablesTestSuite struct {
+	suite.Suite
+}
+
+func TestIPTablesTestSuite(t *testing.T) {
+	suite.Run(t, new(IPTablesTestSuite))
+}
+
+func (i *IPTablesTestSuite) Test_IPTables() {
+	network, _ := config.ParseAddress("10.0.0.0/8")
+	addresses, _ := config.ParseAddress("10.0.0.5-10.0.0.9")
+	excluded, _ := config.ParseAddress("10.1.0.0/16")
+	host, _ := config.ParseAddress("10.0.0.1")
+
+	var tests = []struct {
+		rule     Rule
+		expected [][]string
+	}{
+		{
+			Rule{Match: Match{State: []string{"RELATED", "ESTABLISHED"}}, Action: Action{Verdict: config.ActionReturn}},
+			[][]string{{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"}},
+		},
+		{
+			Rule{Match: Match{Source: &network, Interface: "eth0", Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 80}}}, Action: Action{Verdict: config.ActionAccept}},
+			[][]string{{"-s", "10.0.0.0/8", "-i", "eth0", "-p", "tcp", "-m", "tcp", "--dport", "80", "-j", "ACCEPT"}},
+		},
+		{
+			Rule{Match: Match{Source: &addresses, Protocol: "udp"}, Action: Action{Verdict: config.ActionReject, RejectWith: "icmp-port-unreachable"}},
+			[][]string{{"-m", "iprange", "--src-range", "10.0.0.5-10.0.0.9", "-p", "udp", "-m", "udp", "-j", "REJECT", "--reject-with", "icmp-port-unreachable"}},
+		},
+		{
+			Rule{Match: Match{Source: &network, Exclude: []config.Address{excluded, host}, Protocol: "tcp"}, Action: Action{Verdict: config.ActionReturn}},
+			[][]string{{"-s", "10.0.0.0/8", "-m", "iprange", "!", "--src-range", "10.1.0.0-10.1.255.255", "-m", "iprange", "!", "--src-range", "10.0.0.1-10.0.0.1", "-p", "tcp", "-m", "tcp", "-j", "RETURN"}},
+		},
+		{
+			Rule{Match: Match{Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 80}, {From: 8000, To: 8100}}}, Action: Action{Verdict: config.ActionDrop}},
+			[][]string{{"-p", "tcp", "-m", "multiport", "--dports", "80,8000:8100", "-j", "DROP"}},
+		},
+		{
+			Rule{Match: Match{Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 80}, {From: 443, To: 443}}, OriginalPort: true}, Action: Action{Verdict: config.ActionReturn}},
+			[][]string{
+				{"-p", "tcp", "-m", "conntrack", "--ctorigdstport", "80", "-j", "RETURN"},
+				{"-p", "tcp", "-m", "conntrack", "--ctorigdstport", "443", "-j", "RETURN"},
+			},
+		},
+		{
+			Rule{Match: Match{Limit: "5/min"}, Action: Action{Verdict: config.ActionLog, LogPrefix: "docker-firewall: ", LogLevel: 4}},
+			[][]string{{"-m", "limit", "--limit", "5/min", "-j", "LOG", "--log-prefix", "docker-firewall: "}},
+		},
+		{
+			Rule{Action: Action{Verdict: config.ActionLog, LogLevel: 7}},
+			[][]string{{"-j", "LOG", "--log-level", "7"}},
+		},
+	}
+
+	for _, test := range tests {
+		i.Equal(test.expected, IPTables(test.rule))
+	}
+}
+
+func (i *IPTablesTestSuite) Test_IPTables_MultiportChunks() {
+	ports := []config.PortRange{}
+	for port := 1; port <= 16; port++ {
+		ports = append(ports, config.PortRange{From: port, To: port})
+	}
+
+	rendered := IPTables(Rule{Match: Match{Protocol: "tcp", Ports: ports}, Action: Action{Verdict: config.ActionReturn}})
+	i.Len(rendered, 2)
+	i.Equal([]string{"-p", "tcp", "-m", "multiport", "--dports", "16", "-j", "RETURN"}, rendered[1])
+
+	first := ""
+	for port := 1; port <= 15; port++ {
+		if port > 1 {
+			first += ","
+		}
+		first += strconv.Itoa(port)
+	}
+	i.Equal(first, rendered[0][5])
+}
+
+func (i *IPTablesTestSuite) Test_IPTables_Protocols() {
+	var tests = []struct {
+		protocol string
+		ports    []config.PortRange
+		expected []string
+	}{
+		{"tcp", nil, []string{"-p", "tcp", "-m", "tcp"}},
+		{"udp", nil, []string{"-p", "udp", "-m", "udp"}},
+		{"udplite", nil, []string{"-p", "udplite"}},
+		{"sctp", nil, []string{"-p", "sctp", "-m", "sctp"}},
+		{"dccp", nil, []string{"-p", "dccp", "-m", "dccp"}},
+		{"icmp", nil, []string{"-p", "icmp", "-m", "icmp"}},
+		{"icmpv6", nil, []string{"-p", "icmpv6", "-m", "icmp6"}},
+		{"ipv6-icmp", nil, []string{"-p", "ipv6-icmp", "-m", "icmp6"}},
+		{"esp", nil, []string{"-p", "esp", "-m", "esp"}},
+		{"ah", nil, []string{"-p", "ah", "-m", "ah"}},
+		{"gre", nil, []string{"-p", "gre"}},
+		{"all", nil, []string{"-p", "all"}},
+		{"sctp", []config.PortRange{{From: 9899, To: 9899}}, []string{"-p", "sctp", "-m", "sctp", "--dport", "9899"}},
+		{"udplite", []config.PortRange{{From: 5000, To: 5000}}, []string{"-p", "udplite", "-m", "multiport", "--dports", "5000"}},
+	}
+
+	for _, test := range tests {
+		rendered := IPTables(Rule{Match: Match{Protocol: test.protocol, Ports: test.ports}, Action: Action{Verdict: config.ActionReturn}})
+		i.Equal([][]string{append(test.expected, "-j", "RETURN")}, rendered, test.protocol)
+	}
+}
@@ -0,0 +1,237 @@
+package compiler
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Covers reports if the match matches every packet the other one matches.
+// It is conservative, a rate limited match covers nothing.
+func (m Match) Covers(other Match) bool {
+	if m.Limit != "" {
+		return false
+	}
+
+	if !sourcesCover(m.sources(), other.sources()) || !excludes(other, m.Exclude) {
+		return false
+	}
+
+	if !interfaceCovers(m.Interface, other.Interface) {
+		return false
+	}
+
+	if m.Protocol != "" && m.Protocol != other.Protocol {
+		return false
+	}
+
+	if len(m.Ports) > 0 && (len(other.Ports) == 0 || m.OriginalPort != other.OriginalPort || !portsCover(m.Ports, other.Ports)) {
+		return false
+	}
+
+	return statesCover(m.State, other.State)
+}
+
+// Terminates reports if the action ends the traversal of the chain
+func (a Action) Terminates() bool {
+	return a.Verdict != config.ActionLog
+}
+
+// sources returns the sources of the match, nil when it matches any source
+func (m Match) sources() []config.Address {
+	switch {
+	case m.Set != nil:
+		return m.Set.Entries
+	case m.Source != nil:
+		return []config.Address{*m.Source}
+	default:
+		return nil
+	}
+}
+
+// sourcesCover reports if every source of other is covered by one of sources
+func sourcesCover(sources, other []config.Address) bool {
+	if sources == nil {
+		return true
+	}
+
+	if other == nil {
+		return false
+	}
+
+	for _, source := range other {
+		covered := false
+		for _, candidate := range sources {
+			if addressCovers(candidate, source) {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
+
+// interfaceCovers reports if an interface match covers another one, a
+// trailing + matching every interface with that prefix
+func interfaceCovers(iface, other string) bool {
+	if iface == "" || iface == other {
+		return true
+	}
+
+	if !strings.HasSuffix(iface, "+") || other == "" {
+		return false
+	}
+
+	return strings.HasPrefix(strings.TrimSuffix(other, "+"), strings.TrimSuffix(iface, "+"))
+}
+
+// excludes reports if the match never matches the excluded sources, either
+// because its sources do not overlap them or because it excludes them too
+func excludes(match Match, excluded []config.Address) bool {
+	for _, address := range excluded {
+		if addressesExcluded(match.Exclude, address) {
+			continue
+		}
+
+		sources := match.sources()
+		if sources == nil {
+			return false
+		}
+
+		for _, source := range sources {
+			if addressesOverlap(source, address) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// addressesExcluded reports if one of the excluded addresses covers the address
+func addressesExcluded(excluded []config.Address, address config.Address) bool {
+	for _, candidate := range excluded {
+		if addressCovers(candidate, address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addressCovers reports if an address covers another one
+func addressCovers(address, other config.Address) bool {
+	start, end := addressBounds(address)
+	otherStart, otherEnd := addressBounds(other)
+
+	return len(start) == len(otherStart) && bytes.Compare(start, otherStart) <= 0 && bytes.Compare(otherEnd, end) <= 0
+}
+
+// addressesOverlap reports if two addresses have an IP in common
+func addressesOverlap(address, other config.Address) bool {
+	start, end := addressBounds(address)
+	otherStart, otherEnd := addressBounds(other)
+
+	return len(start) == len(otherStart) && bytes.Compare(start, otherEnd) <= 0 && bytes.Compare(otherStart, end) <= 0
+}
+
+// addressBounds returns the first and last IP of an address, ignoring its negation
+func addressBounds(address config.Address) (net.IP, net.IP) {
+	if address.IsRange() {
+		return ipBytes(address.Start), ipBytes(address.End)
+	}
+
+	start := ipBytes(address.Network.IP.Mask(address.Network.Mask))
+	end := make(net.IP, len(start))
+	for i := range start {
+		end[i] = start[i] | ^address.Network.Mask[i]
+	}
+
+	return start, end
+}
+
+// ipBytes returns the 4 bytes of an IPv4 address, or the 16 of an IPv6 one
+func ipBytes(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+
+	return ip.To16()
+}
+
+// portsCover reports if every port of other is in ports
+func portsCover(ports, other []config.PortRange) bool {
+	merged := mergePorts(ports)
+
+	for _, port := range other {
+		covered := false
+		for _, span := range merged {
+			if span.From <= port.From && port.To <= span.To {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mergePorts returns the ports sorted, with the adjacent and overlapping ranges merged
+func mergePorts(ports []config.PortRange) []config.PortRange {
+	sorted := append([]config.PortRange{}, ports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+
+	merged := []config.PortRange{}
+	for _, port := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && port.From <= merged[last].To+1 {
+			if port.To > merged[last].To {
+				merged[last].To = port.To
+			}
+			continue
+		}
+
+		merged = append(merged, port)
+	}
+
+	return merged
+}
+
+// statesCover reports if a conntrack state match covers another one
+func statesCover(states, other []string) bool {
+	if len(states) == 0 {
+		return true
+	}
+
+	if len(other) == 0 {
+		return false
+	}
+
+	for _, state := range other {
+		found := false
+		for _, candidate := range states {
+			if candidate == state {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
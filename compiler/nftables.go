@@ -0,0 +1,186 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// nftLogLevels are the nftables names of the syslog levels
+var nftLogLevels = []string{"emerg", "alert", "crit", "err", "warn", "notice", "info", "debug"}
+
+// NFTables renders a rule as nftables expressions of the family, one for
+// each of the rules IPTables renders, so the rules of both renderings match
+// one to one.
+func NFTables(rule Rule, family Family) []string {
+	address := "ip"
+	if family == IPv6 {
+		address = "ip6"
+	}
+
+	base := []string{}
+
+	if rule.Match.Source != nil {
+		base = append(base, address+" saddr "+nftAddress(*rule.Match.Source))
+	}
+
+	if rule.Match.Set != nil {
+		base = append(base, address+" saddr @"+rule.Match.Set.Name)
+	}
+
+	for _, excluded := range rule.Match.Exclude {
+		base = append(base, address+" saddr != "+nftAddress(excluded))
+	}
+
+	if rule.Match.Interface != "" {
+		base = append(base, fmt.Sprintf("iifname %q", nftInterface(rule.Match.Interface)))
+	}
+
+	if len(rule.Match.State) > 0 {
+		base = append(base, "ct state "+strings.ToLower(strings.Join(rule.Match.State, ",")))
+	}
+
+	statements := []string{}
+	if rule.Match.Limit != "" {
+		statements = append(statements, "limit rate "+nftRate(rule.Match.Limit))
+	}
+	statements = append(statements, nftVerdict(rule.Action, family))
+
+	rendered := []string{}
+	for _, match := range nftProtocolMatches(rule.Match.Protocol, rule.Match.Ports, rule.Match.OriginalPort) {
+		expression := append([]string{}, base...)
+		if match != "" {
+			expression = append(expression, match)
+		}
+		expression = append(expression, statements...)
+		rendered = append(rendered, strings.Join(expression, " "))
+	}
+
+	return rendered
+}
+
+// nftProtocolMatches renders the protocol and destination port matches, in
+// the same groups of ports as protocolMatches. The protocols with ports all
+// have their own nftables match.
+func nftProtocolMatches(protocol string, ports []config.PortRange, original bool) []string {
+	if protocol == "" {
+		return []string{""}
+	}
+
+	l4proto := "meta l4proto " + protocol
+	switch protocol {
+	case "all":
+		l4proto = ""
+	case "icmpv6":
+		l4proto = "meta l4proto ipv6-icmp"
+	}
+
+	matches := []string{}
+	for _, group := range portGroups(ports, original) {
+		switch {
+		case len(group) == 0:
+			matches = append(matches, l4proto)
+		case original:
+			matches = append(matches, l4proto+" ct original proto-dst "+nftPorts(group))
+		default:
+			matches = append(matches, protocol+" dport "+nftPorts(group))
+		}
+	}
+
+	return matches
+}
+
+// nftAddress renders an address, a range by its bounds
+func nftAddress(address config.Address) string {
+	if address.IsRange() {
+		return address.Start.String() + "-" + address.End.String()
+	}
+
+	return address.Value
+}
+
+// nftPorts renders ports as a single port or range, or as an anonymous set
+func nftPorts(ports []config.PortRange) string {
+	values := []string{}
+	for _, port := range ports {
+		value := strconv.Itoa(port.From)
+		if port.IsRange() {
+			value = fmt.Sprintf("%d-%d", port.From, port.To)
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	return "{ " + strings.Join(values, ", ") + " }"
+}
+
+// nftInterface renders an interface name, a trailing + matching a prefix
+// becoming a wildcard
+func nftInterface(name string) string {
+	if strings.HasSuffix(name, "+") {
+		return strings.TrimSuffix(name, "+") + "*"
+	}
+
+	return name
+}
+
+// nftRate renders a limit rate, such as 5/min, with the units nftables uses
+func nftRate(rate string) string {
+	units := map[string]string{"s": "second", "sec": "second", "second": "second", "m": "minute", "min": "minute", "minute": "minute", "h": "hour", "hour": "hour", "d": "day", "day": "day"}
+
+	parts := strings.SplitN(rate, "/", 2)
+	if len(parts) == 2 {
+		if unit, ok := units[parts[1]]; ok {
+			return parts[0] + "/" + unit
+		}
+	}
+
+	return rate
+}
+
+// nftVerdict renders the statement of an action, the reject types being the
+// iptables ones of the family
+func nftVerdict(action Action, family Family) string {
+	switch action.Verdict {
+	case config.ActionAccept:
+		return "accept"
+	case config.ActionDrop:
+		return "drop"
+	case config.ActionReject:
+		if action.RejectWith == "" {
+			return "reject"
+		}
+		if action.RejectWith == "tcp-reset" {
+			return "reject with tcp reset"
+		}
+
+		icmp := "icmp"
+		if family == IPv6 {
+			icmp = "icmpv6"
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(action.RejectWith, "icmp6-"), "icmp-")
+		switch name {
+		case "proto-unreachable":
+			name = "prot-unreachable"
+		case "adm-prohibited":
+			name = "admin-prohibited"
+		}
+		return fmt.Sprintf("reject with %s type %s", icmp, name)
+	case config.ActionLog:
+		log := []string{"log"}
+		if action.LogPrefix != "" {
+			log = append(log, "prefix", strconv.Quote(action.LogPrefix))
+		}
+		if action.LogLevel != defaultLogLevel && action.LogLevel >= 0 && action.LogLevel < len(nftLogLevels) {
+			log = append(log, "level", nftLogLevels[action.LogLevel])
+		}
+		return strings.Join(log, " ")
+	default:
+		return "return"
+	}
+}
@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type NFTablesTestSuite struct {
+	suite.Suite
+}
+
+func TestNFTablesTestSuite(t *testing.T) {
+	suite.Run(t, new(NFTablesTestSuite))
+}
+
+func (n *NFTablesTestSuite) Test_NFTables() {
+	host, _ := config.ParseAddress("10.1.1.1")
+	network, _ := config.ParseAddress("10.0.0.0/8")
+	addresses, _ := config.ParseAddress("10.0.0.5-10.0.0.50")
+	excluded, _ := config.ParseAddress("10.0.0.1")
+	ipv6, _ := config.ParseAddress("fd00::1")
+	returned := Action{Verdict: config.ActionReturn}
+
+	var tests = []struct {
+		rule     Rule
+		family   Family
+		expected []string
+	}{
+		{Rule{Action: Action{Verdict: config.ActionDrop}}, IPv4, []string{"drop"}},
+		{Rule{Match: Match{State: []string{"RELATED", "ESTABLISHED"}}, Action: returned}, IPv4, []string{"ct state related,established return"}},
+		{
+			Rule{Match: Match{Source: &host, Interface: "eth0", Protocol: "tcp", Ports: []config.PortRange{{From: 8080, To: 8080}}}, Action: returned},
+			IPv4,
+			[]string{`ip saddr 10.1.1.1 iifname "eth0" tcp dport 8080 return`},
+		},
+		{
+			Rule{Match: Match{Source: &ipv6, Protocol: "udp", Ports: []config.PortRange{{From: 53, To: 53}}}, Action: returned},
+			IPv6,
+			[]string{"ip6 saddr fd00::1 udp dport 53 return"},
+		},
+		{Rule{Match: Match{Interface: "docker+", Protocol: "tcp"}, Action: returned}, IPv4, []string{`iifname "docker*" meta l4proto tcp return`}},
+		{
+			Rule{Match: Match{Source: &network, Exclude: []config.Address{excluded, addresses}, Protocol: "tcp", Ports: []config.PortRange{{From: 3000, To: 3000}}}, Action: returned},
+			IPv4,
+			[]string{"ip saddr 10.0.0.0/8 ip saddr != 10.0.0.1 ip saddr != 10.0.0.5-10.0.0.50 tcp dport 3000 return"},
+		},
+		{Rule{Match: Match{Source: &addresses}, Action: returned}, IPv4, []string{"ip saddr 10.0.0.5-10.0.0.50 return"}},
+		{Rule{Match: Match{Set: &Set{Name: "docker-firewall-v4-0de54994"}}, Action: returned}, IPv4, []string{"ip saddr @docker-firewall-v4-0de54994 return"}},
+		{
+			Rule{Match: Match{Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 80}, {From: 8000, To: 8100}}}, Action: returned},
+			IPv4,
+			[]string{"tcp dport { 80, 8000-8100 } return"},
+		},
+		{
+			Rule{Match: Match{Protocol: "tcp", Ports: []config.PortRange{{From: 3000, To: 3010}, {From: 443, To: 443}}, OriginalPort: true}, Action: returned},
+			IPv4,
+			[]string{"meta l4proto tcp ct original proto-dst 3000-3010 return", "meta l4proto tcp ct original proto-dst 443 return"},
+		},
+		{
+			Rule{Match: Match{Protocol: "tcp", Ports: []config.PortRange{{From: 443, To: 443}}}, Action: Action{Verdict: config.ActionReject, RejectWith: "tcp-reset"}},
+			IPv4,
+			[]string{"tcp dport 443 reject with tcp reset"},
+		},
+		{Rule{Match: Match{Protocol: "udp"}, Action: Action{Verdict: config.ActionReject, RejectWith: "icmp-proto-unreachable"}}, IPv4, []string{"meta l4proto udp reject with icmp type prot-unreachable"}},
+		{Rule{Match: Match{Protocol: "udp"}, Action: Action{Verdict: config.ActionReject, RejectWith: "icmp6-adm-prohibited"}}, IPv6, []string{"meta l4proto udp reject with icmpv6 type admin-prohibited"}},
+		{
+			Rule{Match: Match{Protocol: "tcp", Ports: []config.PortRange{{From: 22, To: 22}}}, Action: Action{Verdict: config.ActionLog, LogPrefix: "ssh: ", LogLevel: 6}},
+			IPv4,
+			[]string{`tcp dport 22 log prefix "ssh: " level info`},
+		},
+		{Rule{Match: Match{Interface: "docker0"}, Action: Action{Verdict: config.ActionAccept}}, IPv4, []string{`iifname "docker0" accept`}},
+		{
+			Rule{Match: Match{Limit: "5/min"}, Action: Action{Verdict: config.ActionLog, LogPrefix: "docker-firewall: ", LogLevel: defaultLogLevel}},
+			IPv4,
+			[]string{`limit rate 5/minute log prefix "docker-firewall: "`},
+		},
+		{Rule{Match: Match{Protocol: "icmpv6"}, Action: returned}, IPv6, []string{"meta l4proto ipv6-icmp return"}},
+		{Rule{Match: Match{Protocol: "all"}, Action: returned}, IPv4, []string{"return"}},
+	}
+
+	for _, test := range tests {
+		n.Equal(test.expected, NFTables(test.rule, test.family), test.rule.String())
+	}
+}
+
+func (n *NFTablesTestSuite) Test_NFTables_MatchesIPTables() {
+	ports := []config.PortRange{}
+	for port := 1; port <= 16; port++ {
+		ports = append(ports, config.PortRange{From: port, To: port})
+	}
+
+	rule := Rule{Match: Match{Protocol: "tcp", Ports: ports}, Action: Action{Verdict: config.ActionReturn}}
+	rendered := NFTables(rule, IPv4)
+	n.Len(rendered, len(IPTables(rule)))
+	n.Equal("tcp dport 16 return", rendered[1])
+}
@@ -0,0 +1,113 @@
+package compiler
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Report counts the rules an optimization removed from a chain
+type Report struct {
+	Before     int
+	After      int
+	Duplicates int
+	Shadowed   int
+	Merged     int
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d rules optimized to %d: %d duplicates, %d shadowed, %d merged", r.Before, r.After, r.Duplicates, r.Shadowed, r.Merged)
+}
+
+// Optimize returns a chain deciding every packet the same way with fewer
+// rules. It removes the rules identical to an earlier one, the rules an
+// earlier terminating rule covers, since they can never decide a packet,
+// and merges consecutive rules differing only by their ports. A merged rule
+// keeps the origin of its first rule. Only the configuration rules are
+// optimized, the rules docker-firewall adds are kept.
+func Optimize(chain []Rule) ([]Rule, Report) {
+	report := Report{Before: len(chain)}
+	kept := []Rule{}
+
+	for _, rule := range chain {
+		if rule.Origin < 0 {
+			kept = append(kept, rule)
+			continue
+		}
+
+		switch {
+		case duplicated(kept, rule):
+			report.Duplicates++
+		case shadowed(kept, rule):
+			report.Shadowed++
+		default:
+			kept = append(kept, rule)
+		}
+	}
+
+	optimized := []Rule{}
+	for _, rule := range kept {
+		last := len(optimized) - 1
+		if last >= 0 && mergeable(optimized[last], rule) {
+			optimized[last].Match.Ports = appendPorts(optimized[last].Match.Ports, rule.Match.Ports)
+			report.Merged++
+			continue
+		}
+
+		optimized = append(optimized, rule)
+	}
+
+	report.After = len(optimized)
+
+	return optimized, report
+}
+
+func duplicated(chain []Rule, rule Rule) bool {
+	for _, previous := range chain {
+		if reflect.DeepEqual(previous.Match, rule.Match) && previous.Action == rule.Action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func shadowed(chain []Rule, rule Rule) bool {
+	for _, previous := range chain {
+		if previous.Action.Terminates() && previous.Match.Covers(rule.Match) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeable reports if two rules can be a single rule matching both their ports
+func mergeable(rule, next Rule) bool {
+	if rule.Origin < 0 || next.Origin < 0 || rule.Action != next.Action {
+		return false
+	}
+
+	if len(rule.Match.Ports) == 0 || len(next.Match.Ports) == 0 || rule.Match.OriginalPort || next.Match.OriginalPort {
+		return false
+	}
+
+	match := next.Match
+	match.Ports = rule.Match.Ports
+
+	return reflect.DeepEqual(rule.Match, match)
+}
+
+// appendPorts returns a copy of ports followed by the other ports it does not cover
+func appendPorts(ports, other []config.PortRange) []config.PortRange {
+	merged := append([]config.PortRange{}, ports...)
+
+	for _, port := range other {
+		if !portsCover(merged, []config.PortRange{port}) {
+			merged = append(merged, port)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,138 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type OptimizeTestSuite struct {
+	suite.Suite
+}
+
+func TestOptimizeTestSuite(t *testing.T) {
+	suite.Run(t, new(OptimizeTestSuite))
+}
+
+func (o *OptimizeTestSuite) optimize(rules []config.Rule) ([]string, Report) {
+	optimized, report := Optimize(Chain(rules, IPv4, Policy{}))
+
+	printed := []string{}
+	for _, rule := range optimized {
+		printed = append(printed, rule.String())
+	}
+
+	return printed, report
+}
+
+func (o *OptimizeTestSuite) Test_Optimize_Duplicates() {
+	optimized, report := o.optimize([]config.Rule{{Port: 8080}, {Port: 8080}})
+
+	o.Equal([]string{
+		"state related,established -> return",
+		"tcp dport 8080 -> return",
+		"udp dport 8080 -> return",
+		"all -> drop",
+	}, optimized)
+	o.Equal(Report{Before: 6, After: 4, Duplicates: 2}, report)
+}
+
+func (o *OptimizeTestSuite) Test_Optimize_Shadowed() {
+	optimized, report := o.optimize([]config.Rule{
+		{Protocol: "tcp", Port: 5601},
+		{Protocol: "tcp", Port: 5601, Allow: []string{"10.0.0.0/8"}, Action: config.ActionDrop},
+		{Interface: []string{"eth+"}, Protocol: "tcp", Ports: []config.PortRange{{From: 8000, To: 8100}}},
+		{Interface: []string{"eth0"}, Protocol: "tcp", Port: 8080, Allow: []string{"192.168.1.10-192.168.1.20"}},
+		{Protocol: "tcp", Port: 9000, Allow: []string{"!10.0.0.0/8"}},
+		{Protocol: "tcp", Port: 9000, Allow: []string{"192.168.1.1"}},
+	})
+
+	o.Equal([]string{
+		"state related,established -> return",
+		"tcp dport 5601 -> return",
+		"in eth+ tcp dport 8000-8100 -> return",
+		"except 10.0.0.0/8 tcp dport 9000 -> return",
+		"all -> drop",
+	}, optimized)
+	o.Equal(3, report.Shadowed)
+}
+
+func (o *OptimizeTestSuite) Test_Optimize_KeepsUncovered() {
+	rules := []config.Rule{
+		{Protocol: "tcp", Port: 22, Action: config.ActionLog},
+		{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.1"}},
+		{Protocol: "tcp", Port: 443, Allow: []string{"10.0.0.0/24"}},
+		{Protocol: "tcp", Port: 443, Allow: []string{"10.0.0.0/16"}, Action: config.ActionDrop},
+		{Protocol: "tcp", Port: 3000, OriginalPort: true},
+		{Protocol: "tcp", Port: 3000},
+	}
+
+	optimized, report := o.optimize(rules)
+	o.Len(optimized, 8)
+	o.Equal(Report{Before: 8, After: 8}, report)
+}
+
+func (o *OptimizeTestSuite) Test_Optimize_Merge() {
+	optimized, report := o.optimize([]config.Rule{
+		{Protocol: "tcp", Port: 80, Allow: []string{"10.0.0.1"}},
+		{Protocol: "tcp", Ports: []config.PortRange{{From: 443, To: 443}, {From: 80, To: 80}}, Allow: []string{"10.0.0.1"}},
+		{Protocol: "tcp", Port: 8443, Allow: []string{"10.0.0.1"}, Action: config.ActionAccept},
+		{Protocol: "udp", Port: 53, Allow: []string{"10.0.0.1"}},
+	})
+
+	o.Equal([]string{
+		"state related,established -> return",
+		"from 10.0.0.1 tcp dport 80,443 -> return",
+		"from 10.0.0.1 tcp dport 8443 -> accept",
+		"from 10.0.0.1 udp dport 53 -> return",
+		"all -> drop",
+	}, optimized)
+	o.Equal(Report{Before: 6, After: 5, Merged: 1}, report)
+}
+
+func (o *OptimizeTestSuite) Test_Optimize_DoesNotAliasPorts() {
+	rule := config.Rule{Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 80}}, Allow: []string{"10.0.0.1", "10.0.0.2"}}
+	chain := Compile(rule, 0, IPv4)
+	chain = append(chain, Compile(config.Rule{Protocol: "tcp", Port: 443, Allow: []string{"10.0.0.2"}}, 1, IPv4)...)
+
+	optimized, _ := Optimize(chain)
+	o.Len(optimized, 2)
+	o.Equal([]config.PortRange{{From: 80, To: 80}}, optimized[0].Match.Ports)
+	o.Equal([]config.PortRange{{From: 80, To: 80}, {From: 443, To: 443}}, optimized[1].Match.Ports)
+	o.Equal([]config.PortRange{{From: 80, To: 80}}, rule.Ports)
+}
+
+func (o *OptimizeTestSuite) Test_Covers() {
+	parse := func(entry string) *config.Address {
+		address, err := config.ParseAddress(entry)
+		o.Require().NoError(err)
+		return &address
+	}
+
+	var tests = []struct {
+		match    Match
+		other    Match
+		expected bool
+	}{
+		{Match{}, Match{Protocol: "tcp"}, true},
+		{Match{Protocol: "tcp"}, Match{}, false},
+		{Match{Source: parse("10.0.0.0/8")}, Match{Source: parse("10.1.2.3")}, true},
+		{Match{Source: parse("10.0.0.0/8")}, Match{Source: parse("9.255.255.255-10.0.0.5")}, false},
+		{Match{Exclude: []config.Address{*parse("10.0.0.0/8")}}, Match{Exclude: []config.Address{*parse("10.1.0.0/16")}}, false},
+		{Match{Exclude: []config.Address{*parse("10.1.0.0/16")}}, Match{Exclude: []config.Address{*parse("10.0.0.0/8")}}, true},
+		{Match{Exclude: []config.Address{*parse("10.0.0.0/8")}}, Match{Source: parse("192.168.1.1")}, true},
+		{Match{Exclude: []config.Address{*parse("10.0.0.1")}}, Match{Source: parse("10.0.0.0/24")}, false},
+		{Match{Source: parse("10.0.0.0/8")}, Match{Source: parse("10.0.0.0/24"), Exclude: []config.Address{*parse("10.0.0.1")}}, true},
+		{Match{Source: parse("10.0.0.0/8")}, Match{Source: parse("2001:db8::1")}, false},
+		{Match{Interface: "docker+"}, Match{Interface: "docker_gwbridge"}, true},
+		{Match{Interface: "docker_+"}, Match{Interface: "docker+"}, false},
+		{Match{Protocol: "tcp", Ports: []config.PortRange{{From: 1, To: 100}, {From: 101, To: 200}}}, Match{Protocol: "tcp", Ports: []config.PortRange{{From: 50, To: 150}}}, true},
+		{Match{Limit: "5/min"}, Match{Protocol: "tcp"}, false},
+		{Match{State: []string{"RELATED", "ESTABLISHED"}}, Match{State: []string{"ESTABLISHED"}}, true},
+	}
+
+	for _, test := range tests {
+		o.Equal(test.expected, test.match.Covers(test.other), "%s covers %s", test.match, test.other)
+	}
+}
@@ -0,0 +1,122 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Set is a named ipset of sources, matched by a single rule instead of a
+// rule per source
+type Set struct {
+	Name    string
+	Family  Family
+	Entries []config.Address
+}
+
+// SetPrefix returns the prefix of the names of the sets of a family
+func SetPrefix(family Family) string {
+	if family == IPv6 {
+		return "docker-firewall-v6-"
+	}
+
+	return "docker-firewall-v4-"
+}
+
+// SetName returns the name of the set of a family holding the entries. The
+// name is derived from the entries, so a set is never reused for different
+// entries, and a chain replacing another one matches new sets instead of
+// changing the sets the rules in use match.
+func SetName(family Family, entries []config.Address) string {
+	values := []string{}
+	for _, entry := range entries {
+		values = append(values, entry.Value)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(values, "\n")))
+
+	return SetPrefix(family) + hex.EncodeToString(sum[:4])
+}
+
+// UseSets replaces the rules of a configuration rule differing only by their
+// source with a single rule matching a set of these sources, the excluded
+// sources are still matched by each rule. The rules sharing the same sources
+// share their set, named after its entries.
+func UseSets(chain []Rule, family Family) ([]Rule, []Set) {
+	type group struct {
+		rule    Rule
+		sources []config.Address
+	}
+
+	groups := []*group{}
+	positions := []int{}
+	grouped := []Rule{}
+
+	for _, rule := range chain {
+		source := rule.Match.Source
+		if rule.Origin < 0 || source == nil {
+			grouped = append(grouped, rule)
+			continue
+		}
+
+		key := rule
+		key.Match.Source = nil
+
+		var found *group
+		for _, candidate := range groups {
+			if reflect.DeepEqual(candidate.rule, key) {
+				found = candidate
+				break
+			}
+		}
+
+		if found == nil {
+			found = &group{rule: key}
+			groups = append(groups, found)
+			positions = append(positions, len(grouped))
+			grouped = append(grouped, key)
+		}
+
+		if !containsAddress(found.sources, *source) {
+			found.sources = append(found.sources, *source)
+		}
+	}
+
+	sets := []*Set{}
+	for i, group := range groups {
+		var set *Set
+		for _, candidate := range sets {
+			if reflect.DeepEqual(candidate.Entries, group.sources) {
+				set = candidate
+				break
+			}
+		}
+
+		if set == nil {
+			set = &Set{Name: SetName(family, group.sources), Family: family, Entries: group.sources}
+			sets = append(sets, set)
+		}
+
+		grouped[positions[i]].Match.Set = set
+	}
+
+	result := []Set{}
+	for _, set := range sets {
+		result = append(result, *set)
+	}
+
+	return grouped, result
+}
+
+func containsAddress(addresses []config.Address, address config.Address) bool {
+	for _, candidate := range addresses {
+		if reflect.DeepEqual(candidate, address) {
+			return true
+		}
+	}
+
+	return false
+}
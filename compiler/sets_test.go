@@ -0,0 +1,97 @@
+package compiler
+
+import (
+	"net"
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type SetsTestSuite struct {
+	suite.Suite
+}
+
+func TestSetsTestSuite(t *testing.T) {
+	suite.Run(t, new(SetsTestSuite))
+}
+
+func (s *SetsTestSuite) entries(set Set) []string {
+	values := []string{}
+	for _, entry := range set.Entries {
+		values = append(values, entry.Value)
+	}
+
+	return values
+}
+
+func (s *SetsTestSuite) Test_UseSets() {
+	rules := []config.Rule{
+		{Interface: []string{"eth0", "eth1"}, Port: 9100, Allow: []string{"10.0.0.0/8", "192.168.1.10-192.168.1.20", "!10.0.0.1", "2001:db8::/32"}},
+		{Protocol: "tcp", Port: 22},
+		{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.5"}, Action: config.ActionDrop},
+	}
+
+	chain, sets := UseSets(Chain(rules, IPv4, Policy{}), IPv4)
+
+	printed := []string{}
+	for _, rule := range chain {
+		printed = append(printed, rule.String())
+	}
+	s.Equal([]string{
+		"state related,established -> return",
+		"from set docker-firewall-v4-2f9a21f7 except 10.0.0.1 in eth0 tcp dport 9100 -> return",
+		"from set docker-firewall-v4-2f9a21f7 except 10.0.0.1 in eth0 udp dport 9100 -> return",
+		"from set docker-firewall-v4-2f9a21f7 except 10.0.0.1 in eth1 tcp dport 9100 -> return",
+		"from set docker-firewall-v4-2f9a21f7 except 10.0.0.1 in eth1 udp dport 9100 -> return",
+		"tcp dport 22 -> return",
+		"from set docker-firewall-v4-440a628a tcp dport 443 -> drop",
+		"all -> drop",
+	}, printed)
+
+	s.Len(sets, 2)
+	s.Equal("docker-firewall-v4-2f9a21f7", sets[0].Name)
+	s.Equal([]string{"10.0.0.0/8", "192.168.1.10-192.168.1.20"}, s.entries(sets[0]))
+	s.Equal([]string{"203.0.113.5"}, s.entries(sets[1]))
+
+	s.Equal([][]string{{"-m", "set", "--match-set", "docker-firewall-v4-440a628a", "src", "-p", "tcp", "-m", "tcp", "--dport", "443", "-j", "DROP"}}, IPTables(chain[6]))
+
+	_, sets = UseSets(Chain(rules, IPv6, Policy{}), IPv6)
+	s.Len(sets, 1)
+	s.Equal("docker-firewall-v6-90c2cbc2", sets[0].Name)
+	s.Equal(IPv6, sets[0].Family)
+}
+
+func (s *SetsTestSuite) Test_UseSets_AfterOptimize() {
+	rules := []config.Rule{
+		{Protocol: "tcp", Port: 80, Allow: []string{"10.0.0.1", "10.0.0.2"}},
+		{Protocol: "tcp", Port: 443, Allow: []string{"10.0.0.2"}},
+	}
+
+	optimized, _ := Optimize(Chain(rules, IPv4, Policy{}))
+	chain, sets := UseSets(optimized, IPv4)
+
+	s.Equal("from set docker-firewall-v4-f5047344 tcp dport 80 -> return", chain[1].String())
+	s.Equal("from set docker-firewall-v4-cb5f37b4 tcp dport 80,443 -> return", chain[2].String())
+	s.Equal([]string{"10.0.0.1"}, s.entries(sets[0]))
+	s.Equal([]string{"10.0.0.2"}, s.entries(sets[1]))
+}
+
+func (s *SetsTestSuite) Test_Set_Matches() {
+	rules := []config.Rule{{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.0/8", "192.168.1.1"}}}
+	chain, _ := UseSets(Chain(rules, IPv4, Policy{}), IPv4)
+
+	for source, origin := range map[string]int{"10.1.2.3": 0, "192.168.1.1": 0, "192.168.1.2": DefaultOrigin} {
+		packet := Packet{Source: net.ParseIP(source), Protocol: "tcp", Port: 22}
+		decision, ok := Evaluate(chain, packet)
+		s.True(ok)
+		s.Equal(origin, decision.Rule.Origin, source)
+	}
+
+	network, _ := config.ParseAddress("10.1.0.0/16")
+	other := chain[1].Match
+	other.Set = nil
+	other.Source = &network
+	s.True(chain[1].Match.Covers(other))
+	s.False(other.Covers(chain[1].Match))
+}
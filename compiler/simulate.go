@@ -0,0 +1,113 @@
+package compiler
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Packet defines the packet of a new connection reaching the chain
+type Packet struct {
+	Source    net.IP
+	Interface string
+	Protocol  string
+	Port      int
+}
+
+// Family returns the IP protocol of the packet
+func (p Packet) Family() Family {
+	if p.Source.To4() == nil {
+		return IPv6
+	}
+
+	return IPv4
+}
+
+// Decision defines how a chain decides a packet, Rule being the rule that
+// decided it and Logged the rules that logged it before
+type Decision struct {
+	Rule   Rule
+	Logged []Rule
+}
+
+// Evaluate returns how the chain decides a packet, the first matching rule
+// with a terminating action deciding it. The destination port is assumed to
+// be the original one, as it is when no NAT happened.
+func Evaluate(chain []Rule, packet Packet) (Decision, bool) {
+	decision := Decision{}
+
+	for _, rule := range chain {
+		if !rule.Match.Matches(packet) {
+			continue
+		}
+
+		if !rule.Action.Terminates() {
+			decision.Logged = append(decision.Logged, rule)
+			continue
+		}
+
+		decision.Rule = rule
+		return decision, true
+	}
+
+	return decision, false
+}
+
+// Matches reports if the match matches the packet. A packet is always new,
+// and is never rate limited.
+func (m Match) Matches(packet Packet) bool {
+	if len(m.State) > 0 {
+		return false
+	}
+
+	if sources := m.sources(); sources != nil {
+		matched := false
+		for _, source := range sources {
+			matched = matched || addressContains(source, packet.Source)
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	for _, address := range m.Exclude {
+		if addressContains(address, packet.Source) {
+			return false
+		}
+	}
+
+	if !interfaceCovers(m.Interface, packet.Interface) {
+		return false
+	}
+
+	if m.Protocol != "" && m.Protocol != "all" && protocolName(m.Protocol) != protocolName(packet.Protocol) {
+		return false
+	}
+
+	if len(m.Ports) > 0 {
+		return packet.Port != 0 && portsCover(m.Ports, []config.PortRange{{From: packet.Port, To: packet.Port}})
+	}
+
+	return true
+}
+
+// addressContains reports if an address contains an IP
+func addressContains(address config.Address, ip net.IP) bool {
+	start, end := addressBounds(address)
+	ip = ipBytes(ip)
+
+	return len(ip) == len(start) && bytes.Compare(start, ip) <= 0 && bytes.Compare(ip, end) <= 0
+}
+
+// protocolName returns the canonical name of a protocol
+func protocolName(protocol string) string {
+	protocol = strings.ToLower(protocol)
+	if protocol == "ipv6-icmp" {
+		return "icmpv6"
+	}
+
+	return protocol
+}
@@ -0,0 +1,73 @@
+package compiler
+
+import (
+	"net"
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type SimulateTestSuite struct {
+	suite.Suite
+	rules []config.Rule
+}
+
+func TestSimulateTestSuite(t *testing.T) {
+	suite.Run(t, new(SimulateTestSuite))
+}
+
+func (s *SimulateTestSuite) SetupTest() {
+	s.rules = []config.Rule{
+		{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.0/24"}, Action: config.ActionReject},
+		{Protocol: "tcp", Port: 443},
+		{Protocol: "tcp", Port: 9100, Allow: []string{"10.0.0.0/8", "!10.9.0.0/16"}},
+		{Protocol: "tcp", Port: 22, Action: config.ActionLog},
+		{Interface: []string{"docker+"}},
+		{Protocol: "icmpv6", Action: config.ActionAccept},
+	}
+}
+
+func (s *SimulateTestSuite) evaluate(source, iface, protocol string, port int) Decision {
+	packet := Packet{Source: net.ParseIP(source), Interface: iface, Protocol: protocol, Port: port}
+
+	decision, ok := Evaluate(Chain(s.rules, packet.Family(), Policy{Default: config.PolicyReject, Log: true}), packet)
+	s.Require().True(ok)
+
+	return decision
+}
+
+func (s *SimulateTestSuite) Test_Evaluate() {
+	var tests = []struct {
+		source   string
+		iface    string
+		protocol string
+		port     int
+		origin   int
+		verdict  string
+	}{
+		{"203.0.113.9", "eth0", "tcp", 443, 0, config.ActionReject},
+		{"198.51.100.1", "eth0", "tcp", 443, 1, config.ActionReturn},
+		{"198.51.100.1", "eth0", "udp", 443, DefaultOrigin, config.ActionReject},
+		{"10.2.3.4", "eth0", "tcp", 9100, 2, config.ActionReturn},
+		{"10.9.3.4", "eth0", "tcp", 9100, DefaultOrigin, config.ActionReject},
+		{"192.168.1.1", "eth0", "tcp", 9100, DefaultOrigin, config.ActionReject},
+		{"10.2.3.4", "docker_gwbridge", "udp", 53, 4, config.ActionReturn},
+		{"10.2.3.4", "", "udp", 53, DefaultOrigin, config.ActionReject},
+		{"2001:db8::1", "eth0", "ipv6-icmp", 0, 5, config.ActionAccept},
+		{"2001:db8::1", "eth0", "tcp", 443, 1, config.ActionReturn},
+	}
+
+	for _, test := range tests {
+		decision := s.evaluate(test.source, test.iface, test.protocol, test.port)
+		s.Equal(test.origin, decision.Rule.Origin, "%s %s %s %d", test.source, test.iface, test.protocol, test.port)
+		s.Equal(test.verdict, decision.Rule.Action.Verdict)
+	}
+}
+
+func (s *SimulateTestSuite) Test_Evaluate_Logged() {
+	decision := s.evaluate("192.168.1.1", "eth0", "tcp", 22)
+
+	s.Equal(DefaultOrigin, decision.Rule.Origin)
+	s.Equal([]int{3, DefaultLogOrigin}, []int{decision.Logged[0].Origin, decision.Logged[1].Origin})
+}
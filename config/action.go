@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Actions a rule can take on the packets it matches
+const (
+	// ActionReturn lets the packets through to the Docker rules, it is the default
+	ActionReturn = "return"
+	// ActionAccept accepts the packets, skipping the Docker rules
+	ActionAccept = "accept"
+	// ActionDrop drops the packets
+	ActionDrop = "drop"
+	// ActionReject drops the packets, answering with an error
+	ActionReject = "reject"
+	// ActionLog logs the packets and continues with the next rule
+	ActionLog = "log"
+)
+
+// Default policies deciding the traffic no rule matched
+const (
+	// PolicyDrop drops the traffic, it is the default
+	PolicyDrop = "drop"
+	// PolicyReject drops the traffic, answering with port unreachable
+	PolicyReject = "reject"
+	// PolicyReturn lets the traffic through to the Docker rules
+	PolicyReturn = "return"
+)
+
+// policies lists the default policies accepted in the configuration
+var policies = map[string]bool{
+	"":           true,
+	PolicyDrop:   true,
+	PolicyReject: true,
+	PolicyReturn: true,
+}
+
+// actions lists the actions accepted in a rule
+var actions = map[string]bool{
+	"":           true,
+	ActionReturn: true,
+	ActionAccept: true,
+	ActionDrop:   true,
+	ActionReject: true,
+	ActionLog:    true,
+}
+
+// rejectTypes lists the errors a reject answers with, as named by iptables
+// and ip6tables, each is translated to the other IP protocol
+var rejectTypes = map[string]bool{
+	"icmp-net-unreachable":   true,
+	"icmp-host-unreachable":  true,
+	"icmp-port-unreachable":  true,
+	"icmp-proto-unreachable": true,
+	"icmp-net-prohibited":    true,
+	"icmp-host-prohibited":   true,
+	"icmp-admin-prohibited":  true,
+	"icmp6-no-route":         true,
+	"icmp6-adm-prohibited":   true,
+	"icmp6-addr-unreachable": true,
+	"icmp6-port-unreachable": true,
+	"tcp-reset":              true,
+}
+
+// logLevels maps the syslog level names to their number
+var logLevels = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"error":   3,
+	"err":     3,
+	"warning": 4,
+	"warn":    4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// maxLogPrefix is the longest log prefix accepted by iptables
+const maxLogPrefix = 29
+
+// LogLevel returns the syslog level of a log_level, given by name or number
+func LogLevel(level string) (int, error) {
+	if number, ok := logLevels[level]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(level)
+	if err != nil || number < 0 || number > 7 {
+		return 0, fmt.Errorf("invalid log level %q", level)
+	}
+
+	return number, nil
+}
+
+// validateAction checks the action of a rule and its options
+func validateAction(rule Rule) []string {
+	messages := []string{}
+
+	if !actions[rule.Action] {
+		return append(messages, fmt.Sprintf("unknown action %q", rule.Action))
+	}
+
+	if rule.RejectWith != "" {
+		switch {
+		case rule.Action != ActionReject:
+			messages = append(messages, "reject_with requires the reject action")
+		case !rejectTypes[rule.RejectWith]:
+			messages = append(messages, fmt.Sprintf("unknown reject_with %q", rule.RejectWith))
+		case rule.RejectWith == "tcp-reset" && rule.Protocol != "tcp":
+			messages = append(messages, "reject_with tcp-reset requires the tcp protocol")
+		}
+	}
+
+	if (rule.LogPrefix != "" || rule.LogLevel != "") && rule.Action != ActionLog {
+		messages = append(messages, "log_prefix and log_level require the log action")
+	}
+
+	if len(rule.LogPrefix) > maxLogPrefix {
+		messages = append(messages, fmt.Sprintf("log_prefix %q is longer than %d characters", rule.LogPrefix, maxLogPrefix))
+	}
+
+	if rule.LogLevel != "" {
+		_, err := LogLevel(rule.LogLevel)
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	return messages
+}
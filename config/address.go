@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Address defines a parsed allow entry, which can be an IP, a CIDR or a
+// dash separated IP range, optionally negated with a leading !
+type Address struct {
+	Negate  bool
+	Value   string
+	Network *net.IPNet
+	Start   net.IP
+	End     net.IP
+}
+
+// ParseAddress parses and validates an allow entry
+func ParseAddress(entry string) (Address, error) {
+	address := Address{Value: strings.TrimSpace(entry)}
+
+	if strings.HasPrefix(address.Value, "!") {
+		address.Negate = true
+		address.Value = strings.TrimSpace(strings.TrimPrefix(address.Value, "!"))
+	}
+
+	if address.Value == "" {
+		return address, fmt.Errorf("invalid address %q: empty address", entry)
+	}
+
+	if strings.Contains(address.Value, "-") {
+		bounds := strings.SplitN(address.Value, "-", 2)
+		address.Start = net.ParseIP(strings.TrimSpace(bounds[0]))
+		address.End = net.ParseIP(strings.TrimSpace(bounds[1]))
+
+		if address.Start == nil || address.End == nil {
+			return address, fmt.Errorf("invalid address range %q", entry)
+		}
+
+		if (address.Start.To4() == nil) != (address.End.To4() == nil) {
+			return address, fmt.Errorf("invalid address range %q: mixed IPv4 and IPv6", entry)
+		}
+
+		if bytes.Compare(address.Start.To16(), address.End.To16()) > 0 {
+			return address, fmt.Errorf("invalid address range %q: start is after end", entry)
+		}
+
+		address.Value = address.Start.String() + "-" + address.End.String()
+
+		return address, nil
+	}
+
+	if strings.Contains(address.Value, "/") {
+		_, network, err := net.ParseCIDR(address.Value)
+		if err != nil {
+			return address, fmt.Errorf("invalid address %q: invalid CIDR", entry)
+		}
+
+		address.Network = network
+
+		return address, nil
+	}
+
+	ip := net.ParseIP(address.Value)
+	if ip == nil {
+		return address, fmt.Errorf("invalid address %q: not an IP, CIDR or range", entry)
+	}
+
+	bits := 32
+	if ip.To4() == nil {
+		bits = 128
+	}
+	address.Network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+
+	return address, nil
+}
+
+// IsRange reports if the address is an IP range
+func (a Address) IsRange() bool {
+	return a.Start != nil
+}
+
+// IsIPv6 reports if the address belongs to the IPv6 family
+func (a Address) IsIPv6() bool {
+	if a.IsRange() {
+		return a.Start.To4() == nil
+	}
+
+	return a.Network.IP.To4() == nil
+}
+
+// String returns the address as written in the configuration
+func (a Address) String() string {
+	if a.Negate {
+		return "!" + a.Value
+	}
+
+	return a.Value
+}
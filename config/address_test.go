@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AddressTestSuite struct {
+	suite.Suite
+}
+
+func TestAddressTestSuite(t *testing.T) {
+	suite.Run(t, new(AddressTestSuite))
+}
+
+func (a *AddressTestSuite) Test_ParseAddress() {
+	var tests = []struct {
+		entry  string
+		negate bool
+		value  string
+		rng    bool
+		ipv6   bool
+	}{
+		{"10.1.1.1", false, "10.1.1.1", false, false},
+		{"10.0.0.0/8", false, "10.0.0.0/8", false, false},
+		{"!10.0.0.1", true, "10.0.0.1", false, false},
+		{"! 10.0.0.1", true, "10.0.0.1", false, false},
+		{"10.0.0.5-10.0.0.50", false, "10.0.0.5-10.0.0.50", true, false},
+		{"!10.0.0.5 - 10.0.0.50", true, "10.0.0.5-10.0.0.50", true, false},
+		{"fd00::1", false, "fd00::1", false, true},
+		{"2001:db8::/32", false, "2001:db8::/32", false, true},
+		{"fd00::1-fd00::ff", false, "fd00::1-fd00::ff", true, true},
+	}
+
+	for _, test := range tests {
+		address, err := ParseAddress(test.entry)
+		a.NoError(err, test.entry)
+		a.Equal(test.negate, address.Negate, test.entry)
+		a.Equal(test.value, address.Value, test.entry)
+		a.Equal(test.rng, address.IsRange(), test.entry)
+		a.Equal(test.ipv6, address.IsIPv6(), test.entry)
+	}
+}
+
+func (a *AddressTestSuite) Test_ParseAddress_Invalid() {
+	var tests = []struct {
+		entry    string
+		expected string
+	}{
+		{"", `invalid address "": empty address`},
+		{"!", `invalid address "!": empty address`},
+		{"10.0.0.300", `invalid address "10.0.0.300": not an IP, CIDR or range`},
+		{"10.0.0.0/33", `invalid address "10.0.0.0/33": invalid CIDR`},
+		{"10.0.0.50-10.0.0.5", `invalid address range "10.0.0.50-10.0.0.5": start is after end`},
+		{"10.0.0.1-fd00::1", `invalid address range "10.0.0.1-fd00::1": mixed IPv4 and IPv6`},
+		{"10.0.0.1-", `invalid address range "10.0.0.1-"`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseAddress(test.entry)
+		a.EqualError(err, test.expected)
+	}
+}
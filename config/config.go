@@ -9,41 +9,85 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-// Configuration defines the configuration structure
+// Configuration defines the configuration structure. Files lists the
+// configuration file and the patterns of the files it includes.
 type Configuration struct {
-	Config Rules `yaml:"config"`
+	Config Rules    `yaml:"config"`
+	Files  []string `yaml:"-"`
 }
 
-// Rules defines a list of rules
+// Rules defines a list of rules. Include lists files, or glob patterns,
+// whose rules are added after the ones of the configuration file.
+// DefaultPolicy decides the traffic no rule matched, logged with LogDefault.
+// Optimize removes the duplicated and shadowed rules and merges their ports.
+// IPSet matches the allow entries of each rule with an ipset.
 type Rules struct {
-	Rules []Rule
+	Backend       string   `yaml:"backend,omitempty"`
+	Swarm         bool     `yaml:"swarm,omitempty"`
+	Include       []string `yaml:"include,omitempty"`
+	DefaultPolicy string   `yaml:"default_policy,omitempty"`
+	LogDefault    bool     `yaml:"log_default,omitempty"`
+	Optimize      bool     `yaml:"optimize,omitempty"`
+	IPSet         bool     `yaml:"ipset,omitempty"`
+	Rules         []Rule
 }
 
-// Rule defines a rule
+// Rule defines a rule. OriginalPort matches the destination port before it
+// was translated, as needed for ports published through the Swarm routing mesh.
+// Name optionally identifies the rule in the metrics. Action is what to do
+// with the matching packets, returning them to the Docker rules by default.
 type Rule struct {
-	Interface []string `yaml:"interface,omitempty"`
-	Protocol  string   `yaml:"protocol,omitempty"`
-	Port      int      `yaml:"port,omitempty"`
-	Allow     []string `yaml:"allow,omitempty"`
+	Name         string      `yaml:"name,omitempty"`
+	Interface    []string    `yaml:"interface,omitempty"`
+	Protocol     string      `yaml:"protocol,omitempty"`
+	Port         int         `yaml:"port,omitempty"`
+	Ports        []PortRange `yaml:"ports,omitempty"`
+	OriginalPort bool        `yaml:"original_port,omitempty"`
+	Allow        []string    `yaml:"allow,omitempty"`
+	Action       string      `yaml:"action,omitempty"`
+	RejectWith   string      `yaml:"reject_with,omitempty"`
+	LogPrefix    string      `yaml:"log_prefix,omitempty"`
+	LogLevel     string      `yaml:"log_level,omitempty"`
 }
 
-// NewConfiguration reads and parse the configuration file
+// NewConfiguration reads and parse the configuration file and the files it
+// includes. They are decoded strictly, an unknown key being an error rather
+// than a rule silently missing a restriction.
 func NewConfiguration(configDirectory string) (*Configuration, error) {
-	if _, err := os.Stat(path.Join(configDirectory, "config.yml")); err != nil {
-		return nil, fmt.Errorf("%s/config.yml did not exist: %v", configDirectory, err)
-	}
-
-	data, err := ioutil.ReadFile(path.Join(configDirectory, "config.yml"))
+	data, err := readConfiguration(configDirectory)
 	if err != nil {
-		return nil, fmt.Errorf("fail to read the file %s: %v", configDirectory, err)
+		return nil, err
 	}
 
 	var configuration Configuration
 
-	err = yaml.Unmarshal(data, &configuration)
+	err = yaml.UnmarshalStrict(data, &configuration)
 	if err != nil {
 		return nil, fmt.Errorf("unable to decode into struct, %v", err)
 	}
 
+	err = configuration.include(configDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	err = configuration.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	return &configuration, nil
 }
+
+func readConfiguration(configDirectory string) ([]byte, error) {
+	if _, err := os.Stat(path.Join(configDirectory, "config.yml")); err != nil {
+		return nil, fmt.Errorf("%s/config.yml did not exist: %v", configDirectory, err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(configDirectory, "config.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("fail to read the file %s: %v", configDirectory, err)
+	}
+
+	return data, nil
+}
@@ -45,6 +45,7 @@ func (c *ConfigTestSuite) Test_Config_Success() {
 	}
 
 	configExpected.Config.Rules = append(configExpected.Config.Rules, rule1, rule2, rule3)
+	configExpected.Files = []string{"etc/docker-firewall/config.yml"}
 
 	var configYaml = []byte(`
 config:
@@ -96,3 +97,147 @@ test::alberto
 	_, err := NewConfiguration("etc/docker-firewall")
 	c.Errorf(err, "configuration error: While parsing config: yaml: line 5: could not find expected ':'")
 }
+
+func (c *ConfigTestSuite) Test_Config_UnknownKey() {
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte(`
+config:
+  rules:
+  - port: 3000
+    alow:
+    - 10.0.0.0/8
+`), 0644)
+	_, err := NewConfiguration("etc/docker-firewall")
+	c.Error(err)
+	c.Contains(err.Error(), "field alow not found")
+
+	c.filesystem.MkdirAll("etc/docker-firewall/rules.d", 0755)
+	defer c.filesystem.RemoveAll("etc/docker-firewall/rules.d")
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte("config:\n  include:\n  - rules.d/*.yml\n"), 0644)
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/rules.d/a.yml", []byte("rules:\n- port: 4000\n  protocl: tcp\n"), 0644)
+	_, err = NewConfiguration("etc/docker-firewall")
+	c.Error(err)
+	c.Contains(err.Error(), "field protocl not found")
+}
+
+func (c *ConfigTestSuite) Test_Config_InvalidAllow() {
+	var configYaml = []byte(`
+config:
+  rules:
+  - port: 3000
+    allow:
+    - 10.0.0.0/8
+    - 10.0.0.5-10.0.0.50
+    - "!10.0.0.1"
+  - port: 6000
+    allow:
+    - 10.0.0.300
+`)
+
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", configYaml, 0644)
+	_, err := NewConfiguration("etc/docker-firewall")
+	c.EqualError(err, `configuration error in rule 1: invalid address "10.0.0.300": not an IP, CIDR or range`)
+}
+
+func (c *ConfigTestSuite) Test_Config_DefaultPolicy() {
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte(`
+config:
+  default_policy: reject
+  log_default: true
+  rules:
+  - port: 3000
+`), 0644)
+	config, err := NewConfiguration("etc/docker-firewall")
+	c.Require().NoError(err)
+	c.Equal(PolicyReject, config.Config.DefaultPolicy)
+	c.True(config.Config.LogDefault)
+
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte("config:\n  default_policy: allow\n"), 0644)
+	_, err = NewConfiguration("etc/docker-firewall")
+	c.EqualError(err, `configuration error: unknown default_policy "allow"`)
+}
+
+func (c *ConfigTestSuite) Test_Config_IPSet() {
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte("config:\n  ipset: true\n"), 0644)
+	config, err := NewConfiguration("etc/docker-firewall")
+	c.Require().NoError(err)
+	c.True(config.Config.IPSet)
+
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte("config:\n  backend: nftables\n  ipset: true\n"), 0644)
+	_, err = NewConfiguration("etc/docker-firewall")
+	c.EqualError(err, "configuration error: ipset requires the iptables backend")
+}
+
+func (c *ConfigTestSuite) Test_Config_Ports() {
+	var configYaml = []byte(`
+config:
+  rules:
+  - protocol: tcp
+    ports:
+    - 80
+    - 443
+    - "8000-8100"
+`)
+
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", configYaml, 0644)
+	config, err := NewConfiguration("etc/docker-firewall")
+	c.NoError(err)
+
+	expected := []PortRange{{From: 80, To: 80}, {From: 443, To: 443}, {From: 8000, To: 8100}}
+	c.Equal(expected, config.Config.Rules[0].Ports)
+	c.Equal(expected, config.Config.Rules[0].PortRanges())
+}
+
+func (c *ConfigTestSuite) Test_Config_InvalidPorts() {
+	var tests = []struct {
+		ports    string
+		expected string
+	}{
+		{`"8100-8000"`, "configuration error in rule 0: invalid port range 8100-8000: start is after end"},
+		{`70000`, "configuration error in rule 0: invalid port 70000: ports must be between 1 and 65535"},
+		{`"http"`, `invalid port "http"`},
+	}
+
+	for _, test := range tests {
+		configYaml := []byte("config:\n  rules:\n  - ports:\n    - " + test.ports + "\n")
+
+		afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", configYaml, 0644)
+		_, err := NewConfiguration("etc/docker-firewall")
+		c.Error(err)
+		c.Contains(err.Error(), test.expected)
+	}
+}
+
+func (c *ConfigTestSuite) Test_Config_Include() {
+	c.filesystem.MkdirAll("etc/docker-firewall/rules.d", 0755)
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/config.yml", []byte(`
+config:
+  include:
+  - rules.d/*.yml
+  - kibana.yml
+  rules:
+  - port: 3000
+`), 0644)
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/rules.d/b.yml", []byte("rules:\n- port: 5000\n"), 0644)
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/rules.d/a.yml", []byte("rules:\n- port: 4000\n- port: 4001\n"), 0644)
+	afero.WriteFile(c.filesystem, "etc/docker-firewall/kibana.yml", []byte("rules:\n- port: 5601\n"), 0644)
+	defer c.filesystem.RemoveAll("etc/docker-firewall/rules.d")
+	defer c.filesystem.Remove("etc/docker-firewall/kibana.yml")
+
+	config, err := NewConfiguration("etc/docker-firewall")
+	c.Require().NoError(err)
+
+	ports := []int{}
+	for _, rule := range config.Config.Rules {
+		ports = append(ports, rule.Port)
+	}
+	c.Equal([]int{3000, 4000, 4001, 5000, 5601}, ports)
+	c.Equal([]string{
+		"etc/docker-firewall/config.yml",
+		"etc/docker-firewall/rules.d/*.yml",
+		"etc/docker-firewall/kibana.yml",
+	}, config.Files)
+
+	c.filesystem.Remove("etc/docker-firewall/kibana.yml")
+	_, err = NewConfiguration("etc/docker-firewall")
+	c.EqualError(err, "included file etc/docker-firewall/kibana.yml did not exist")
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// IncludedRules defines the content of a file included by the configuration
+type IncludedRules struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// include appends the rules of the included files, in the order they are
+// included, and records the files of the configuration
+func (c *Configuration) include(configDirectory string) error {
+	files, err := includedFiles(configDirectory, c.Config.Include)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("fail to read the included file %s: %v", file, err)
+		}
+
+		var included IncludedRules
+		err = yaml.UnmarshalStrict(data, &included)
+		if err != nil {
+			return fmt.Errorf("unable to decode %s into struct, %v", file, err)
+		}
+
+		c.Config.Rules = append(c.Config.Rules, included.Rules...)
+	}
+
+	c.Files = append([]string{path.Join(configDirectory, "config.yml")}, includePatterns(configDirectory, c.Config.Include)...)
+
+	return nil
+}
+
+// includePatterns returns the include patterns, relative patterns being
+// relative to the configuration directory
+func includePatterns(configDirectory string, include []string) []string {
+	patterns := []string{}
+
+	for _, pattern := range include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(configDirectory, pattern)
+		}
+		patterns = append(patterns, filepath.Clean(pattern))
+	}
+
+	return patterns
+}
+
+// includedFiles returns the files matching the include patterns, sorted by
+// pattern and name. A pattern without wildcards must match a file.
+func includedFiles(configDirectory string, include []string) ([]string, error) {
+	files := []string{}
+
+	for _, pattern := range includePatterns(configDirectory, include) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %q: %v", pattern, err)
+		}
+
+		if len(matches) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+			return nil, fmt.Errorf("included file %s did not exist", pattern)
+		}
+
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
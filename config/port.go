@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange defines a destination port or an inclusive range of ports,
+// written in the configuration as 80 or "8000-8100"
+type PortRange struct {
+	From int
+	To   int
+}
+
+// ParsePortRange parses a port or a dash separated port range
+func ParsePortRange(value string) (PortRange, error) {
+	bounds := strings.SplitN(strings.TrimSpace(value), "-", 2)
+
+	from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port %q", value)
+	}
+
+	to := from
+	if len(bounds) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return PortRange{}, fmt.Errorf("invalid port range %q", value)
+		}
+	}
+
+	return PortRange{From: from, To: to}, nil
+}
+
+// UnmarshalYAML decodes a port given either as a number or as a string
+func (p *PortRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	port, err := ParsePortRange(value)
+	if err != nil {
+		return err
+	}
+
+	*p = port
+
+	return nil
+}
+
+// Validate checks the port bounds
+func (p PortRange) Validate() error {
+	if p.From < 1 || p.From > 65535 || p.To < 1 || p.To > 65535 {
+		return fmt.Errorf("invalid port %s: ports must be between 1 and 65535", p)
+	}
+
+	if p.From > p.To {
+		return fmt.Errorf("invalid port range %s: start is after end", p)
+	}
+
+	return nil
+}
+
+// IsRange reports if more than one port is matched
+func (p PortRange) IsRange() bool {
+	return p.To != p.From
+}
+
+// String returns the port as written in the configuration
+func (p PortRange) String() string {
+	if p.IsRange() {
+		return fmt.Sprintf("%d-%d", p.From, p.To)
+	}
+
+	return strconv.Itoa(p.From)
+}
+
+// PortRanges returns the ports of the rule, merging port and ports
+func (r Rule) PortRanges() []PortRange {
+	ports := []PortRange{}
+	if r.Port != 0 {
+		ports = append(ports, PortRange{From: r.Port, To: r.Port})
+	}
+
+	return append(ports, r.Ports...)
+}
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// protocols lists the protocols accepted in a rule
+var protocols = map[string]bool{
+	"tcp":       true,
+	"udp":       true,
+	"udplite":   true,
+	"sctp":      true,
+	"dccp":      true,
+	"icmp":      true,
+	"icmpv6":    true,
+	"ipv6-icmp": true,
+	"esp":       true,
+	"ah":        true,
+	"gre":       true,
+	"all":       true,
+}
+
+// portProtocols lists the protocols that can match destination ports
+var portProtocols = map[string]bool{
+	"":        true,
+	"tcp":     true,
+	"udp":     true,
+	"udplite": true,
+	"sctp":    true,
+	"dccp":    true,
+}
+
+// backends lists the firewall backends accepted in the configuration
+var backends = map[string]bool{
+	"":         true,
+	"iptables": true,
+	"nftables": true,
+}
+
+var yamlLineRegexp = regexp.MustCompile(`^\s*line (\d+): (.*)$`)
+
+// ValidationError defines an error found in the configuration. Rule is the
+// index of the rule, or -1 when the error is not related to a rule, and Line
+// is the line in the configuration file, or 0 when it is unknown. File is set
+// when the error is in an included file.
+type ValidationError struct {
+	Rule    int
+	File    string
+	Line    int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	location := "configuration error"
+	if e.Rule >= 0 {
+		location += fmt.Sprintf(" in rule %d", e.Rule)
+	}
+
+	switch {
+	case e.File != "" && e.Line > 0:
+		location += fmt.Sprintf(" (%s line %d)", e.File, e.Line)
+	case e.File != "":
+		location += fmt.Sprintf(" (%s)", e.File)
+	case e.Line > 0:
+		location += fmt.Sprintf(" (line %d)", e.Line)
+	}
+
+	return location + ": " + e.Message
+}
+
+// ValidationErrors defines all the errors found in the configuration
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Validate checks every rule of the configuration, returning ValidationErrors
+// with all the errors found
+func (c *Configuration) Validate() error {
+	errs := ValidationErrors{}
+
+	if !backends[c.Config.Backend] {
+		errs = append(errs, ValidationError{Rule: -1, Message: fmt.Sprintf("unknown backend %q", c.Config.Backend)})
+	}
+
+	if c.Config.IPSet && c.Config.Backend == "nftables" {
+		errs = append(errs, ValidationError{Rule: -1, Message: "ipset requires the iptables backend"})
+	}
+
+	if !policies[c.Config.DefaultPolicy] {
+		errs = append(errs, ValidationError{Rule: -1, Message: fmt.Sprintf("unknown default_policy %q", c.Config.DefaultPolicy)})
+	}
+
+	for i, rule := range c.Config.Rules {
+		for _, message := range validateRule(rule) {
+			errs = append(errs, ValidationError{Rule: i, Message: message})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Validate checks a single rule
+func (r Rule) Validate() error {
+	messages := validateRule(r)
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+func validateRule(rule Rule) []string {
+	messages := []string{}
+
+	if rule.Protocol != "" && !protocols[rule.Protocol] {
+		messages = append(messages, fmt.Sprintf("unknown protocol %q", rule.Protocol))
+	}
+
+	ports := rule.PortRanges()
+	for _, port := range ports {
+		err := port.Validate()
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	if len(ports) > 0 && protocols[rule.Protocol] && !portProtocols[rule.Protocol] {
+		messages = append(messages, fmt.Sprintf("protocol %q does not support ports", rule.Protocol))
+	}
+
+	if rule.OriginalPort && len(ports) == 0 {
+		messages = append(messages, "original_port requires a port")
+	}
+
+	messages = append(messages, validateAction(rule)...)
+
+	for _, entry := range rule.Allow {
+		_, err := ParseAddress(entry)
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	for _, name := range rule.Interface {
+		err := validateInterface(name)
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	return messages
+}
+
+// validateInterface checks an interface name the same way the kernel and
+// iptables do, a trailing + matches every interface with that prefix
+func validateInterface(name string) error {
+	base := strings.TrimSuffix(name, "+")
+
+	switch {
+	case name == "":
+		return fmt.Errorf("invalid interface %q: empty name", name)
+	case len(name) > 15:
+		return fmt.Errorf("invalid interface %q: names are limited to 15 characters", name)
+	case base == "." || base == "..":
+		return fmt.Errorf("invalid interface %q", name)
+	case strings.ContainsAny(base, "/:+ \t\n"):
+		return fmt.Errorf("invalid interface %q: names can not contain '/', ':', '+' or spaces", name)
+	}
+
+	return nil
+}
+
+// Validate reads the configuration file in the directory and the files it
+// includes, decoding them strictly to report unknown keys, and validates
+// them. Errors carry the file and line of the rule they refer to.
+func Validate(configDirectory string) error {
+	data, err := readConfiguration(configDirectory)
+	if err != nil {
+		return err
+	}
+
+	var configuration Configuration
+	errs, err := decodeStrict(data, "", &configuration, 0)
+	if err != nil {
+		return err
+	}
+	locations := ruleLocations("", locateRules(data), len(configuration.Config.Rules))
+
+	files, err := includedFiles(configDirectory, configuration.Config.Include)
+	if err != nil {
+		errs = append(errs, ValidationError{Rule: -1, Message: err.Error()})
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			errs = append(errs, ValidationError{Rule: -1, File: file, Message: err.Error()})
+			continue
+		}
+
+		var included IncludedRules
+		decodeErrs, err := decodeStrict(data, file, &included, len(configuration.Config.Rules))
+		if err != nil {
+			errs = append(errs, ValidationError{Rule: -1, File: file, Message: err.Error()})
+			continue
+		}
+
+		errs = append(errs, decodeErrs...)
+		locations = append(locations, ruleLocations(file, locateRules(data), len(included.Rules))...)
+		configuration.Config.Rules = append(configuration.Config.Rules, included.Rules...)
+	}
+
+	err = configuration.Validate()
+	if validationErrs, ok := err.(ValidationErrors); ok {
+		for _, validationErr := range validationErrs {
+			if validationErr.Rule >= 0 && validationErr.Rule < len(locations) {
+				validationErr.File = locations[validationErr.Rule].file
+				validationErr.Line = locations[validationErr.Rule].line
+			}
+			errs = append(errs, validationErr)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ruleLocation is the file and line where a rule starts
+type ruleLocation struct {
+	file string
+	line int
+}
+
+// ruleLocations returns the location of the rules of a file, the lines are
+// unknown when they could not all be located
+func ruleLocations(file string, lines []int, rules int) []ruleLocation {
+	locations := make([]ruleLocation, rules)
+
+	for i := range locations {
+		locations[i].file = file
+		if len(lines) == rules {
+			locations[i].line = lines[i]
+		}
+	}
+
+	return locations
+}
+
+// decodeStrict decodes a file strictly, returning the decoding errors of its
+// rules numbered after the rules decoded before
+func decodeStrict(data []byte, file string, out interface{}, offset int) (ValidationErrors, error) {
+	errs := ValidationErrors{}
+
+	err := yaml.UnmarshalStrict(data, out)
+	if err == nil {
+		return errs, nil
+	}
+
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return nil, fmt.Errorf("unable to decode into struct, %v", err)
+	}
+
+	ruleLines := locateRules(data)
+	for _, message := range typeErr.Errors {
+		validationErr := decodeError(message, ruleLines)
+		validationErr.File = file
+		if validationErr.Rule >= 0 {
+			validationErr.Rule += offset
+		}
+		errs = append(errs, validationErr)
+	}
+
+	return errs, nil
+}
+
+// decodeError converts a yaml decoding error into a ValidationError
+func decodeError(message string, ruleLines []int) ValidationError {
+	validationErr := ValidationError{Rule: -1, Message: message}
+
+	match := yamlLineRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return validationErr
+	}
+
+	validationErr.Line, _ = strconv.Atoi(match[1])
+	validationErr.Message = match[2]
+
+	for i, line := range ruleLines {
+		if line <= validationErr.Line {
+			validationErr.Rule = i
+		}
+	}
+
+	return validationErr
+}
+
+// locateRules returns the line where each rule starts. It understands the
+// block style used in the configuration files, rules written in flow style
+// are not located.
+func locateRules(data []byte) []int {
+	lines := []int{}
+	rulesIndent := -1
+	itemIndent := -1
+
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if rulesIndent < 0 {
+			if trimmed == "rules:" {
+				rulesIndent = indent
+			}
+			continue
+		}
+
+		isItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+
+		if itemIndent < 0 {
+			if !isItem || indent < rulesIndent {
+				break
+			}
+			itemIndent = indent
+		}
+
+		if indent < itemIndent || indent == itemIndent && !isItem {
+			break
+		}
+
+		if indent == itemIndent {
+			lines = append(lines, i+1)
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+)
+
+type ValidateTestSuite struct {
+	suite.Suite
+	filesystem afero.Fs
+}
+
+func TestValidateTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidateTestSuite))
+}
+
+func (v *ValidateTestSuite) SetupTest() {
+	v.filesystem = afero.NewOsFs()
+	v.filesystem.MkdirAll("etc/docker-firewall-validate", 0755)
+}
+
+func (v *ValidateTestSuite) TearDownSuite() {
+	v.filesystem.RemoveAll("etc/docker-firewall-validate")
+}
+
+func (v *ValidateTestSuite) Test_Validate_Success() {
+	var configYaml = []byte(`
+config:
+  rules:
+  - interface:
+    - docker+
+    protocol: tcp
+    ports:
+    - 80
+    - "8000-8100"
+    allow:
+    - 10.0.0.0/8
+`)
+
+	afero.WriteFile(v.filesystem, "etc/docker-firewall-validate/config.yml", configYaml, 0644)
+	v.NoError(Validate("etc/docker-firewall-validate"))
+}
+
+func (v *ValidateTestSuite) Test_Validate_Errors() {
+	var configYaml = []byte(`
+config:
+  backend: ipfw
+  rules:
+  - port: 3000
+    alow:
+    - 10.1.1.1
+  # comment
+  - protocol: tpc
+    port: -1
+  - interface:
+    - averyveryverylongname
+    - eth/0
+    protocol: icmp
+    port: 22
+    allow:
+    - 10.0.0.300
+  - protocol: tcp
+    original_port: true
+`)
+
+	afero.WriteFile(v.filesystem, "etc/docker-firewall-validate/config.yml", configYaml, 0644)
+	err := Validate("etc/docker-firewall-validate")
+	v.Require().Error(err)
+
+	expected := ValidationErrors{
+		{Rule: 0, Line: 6, Message: "field alow not found in type config.Rule"},
+		{Rule: -1, Line: 0, Message: `unknown backend "ipfw"`},
+		{Rule: 1, Line: 9, Message: `unknown protocol "tpc"`},
+		{Rule: 1, Line: 9, Message: "invalid port -1: ports must be between 1 and 65535"},
+		{Rule: 2, Line: 11, Message: `protocol "icmp" does not support ports`},
+		{Rule: 2, Line: 11, Message: `invalid address "10.0.0.300": not an IP, CIDR or range`},
+		{Rule: 2, Line: 11, Message: `invalid interface "averyveryverylongname": names are limited to 15 characters`},
+		{Rule: 2, Line: 11, Message: `invalid interface "eth/0": names can not contain '/', ':', '+' or spaces`},
+		{Rule: 3, Line: 18, Message: "original_port requires a port"},
+	}
+	v.Equal(expected, err)
+	v.Contains(err.Error(), "configuration error in rule 0 (line 6): field alow not found in type config.Rule\n")
+}
+
+func (v *ValidateTestSuite) Test_Validate_Include() {
+	v.filesystem.MkdirAll("etc/docker-firewall-validate/rules.d", 0755)
+	defer v.filesystem.RemoveAll("etc/docker-firewall-validate/rules.d")
+
+	afero.WriteFile(v.filesystem, "etc/docker-firewall-validate/config.yml", []byte(`
+config:
+  include:
+  - rules.d/*.yml
+  rules:
+  - port: 3000
+`), 0644)
+	afero.WriteFile(v.filesystem, "etc/docker-firewall-validate/rules.d/web.yml", []byte(`rules:
+- port: 80
+- port: 443
+  protocl: tcp
+- port: 70000
+`), 0644)
+
+	err := Validate("etc/docker-firewall-validate")
+	v.Require().Error(err)
+
+	expected := ValidationErrors{
+		{Rule: 2, File: "etc/docker-firewall-validate/rules.d/web.yml", Line: 4, Message: "field protocl not found in type config.Rule"},
+		{Rule: 3, File: "etc/docker-firewall-validate/rules.d/web.yml", Line: 5, Message: "invalid port 70000: ports must be between 1 and 65535"},
+	}
+	v.Equal(expected, err)
+	v.Contains(err.Error(), "configuration error in rule 3 (etc/docker-firewall-validate/rules.d/web.yml line 5): invalid port 70000")
+}
+
+func (v *ValidateTestSuite) Test_Rule_ValidateAction() {
+	var tests = []struct {
+		rule     Rule
+		expected string
+	}{
+		{Rule{Port: 443, Allow: []string{"203.0.113.0/24"}, Action: "drop"}, ""},
+		{Rule{Protocol: "tcp", Port: 443, Action: "reject", RejectWith: "tcp-reset"}, ""},
+		{Rule{Port: 22, Action: "log", LogPrefix: "ssh: ", LogLevel: "7"}, ""},
+		{Rule{Port: 443, Action: "deny"}, `unknown action "deny"`},
+		{Rule{Port: 443, RejectWith: "icmp-host-prohibited"}, "reject_with requires the reject action"},
+		{Rule{Port: 443, Action: "reject", RejectWith: "icmp-nope"}, `unknown reject_with "icmp-nope"`},
+		{Rule{Port: 443, Action: "reject", RejectWith: "tcp-reset"}, "reject_with tcp-reset requires the tcp protocol"},
+		{Rule{Port: 22, LogLevel: "info"}, "log_prefix and log_level require the log action"},
+		{Rule{Port: 22, Action: "log", LogPrefix: "a prefix that is way too long: "}, `log_prefix "a prefix that is way too long: " is longer than 29 characters`},
+		{Rule{Port: 22, Action: "log", LogLevel: "8"}, `invalid log level "8"`},
+	}
+
+	for _, test := range tests {
+		err := test.rule.Validate()
+		if test.expected == "" {
+			v.NoError(err)
+		} else {
+			v.EqualError(err, test.expected)
+		}
+	}
+}
+
+func (v *ValidateTestSuite) Test_LocateRules() {
+	var configYaml = []byte(`
+config:
+    rules:
+    -   interface:
+        - docker0
+    -   port: 5601
+
+    -   allow:
+        - 10.0.1.15
+        port: 9100
+    backend: iptables
+`)
+
+	v.Equal([]int{4, 6, 8}, locateRules(configYaml))
+	v.Equal([]int{2, 3}, locateRules([]byte("rules:\n- port: 80\n- port: 443\nother: 1\n")))
+	v.Equal([]int{}, locateRules([]byte("rules: [{port: 80}]\n")))
+}
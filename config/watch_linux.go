@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchEvents are the inotify events changing a file of the configuration,
+// including the files replaced by editors through a rename
+const watchEvents = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// watchDebounce groups the events of a single save
+var watchDebounce = 100 * time.Millisecond
+
+// Watch calls notify when a file matching one of the patterns changes, until
+// the context is done. The directories of the patterns are watched, so files
+// created later or replaced by editors are followed.
+func Watch(ctx context.Context, patterns []string, notify func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inotify: %v", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "inotify")
+	var once sync.Once
+	closeFile := func() {
+		once.Do(func() { file.Close() })
+	}
+	defer closeFile()
+
+	patterns = append([]string{}, patterns...)
+	directories := map[int32]string{}
+	for i, pattern := range patterns {
+		patterns[i] = filepath.Clean(pattern)
+		directory := filepath.Dir(patterns[i])
+
+		wd, err := syscall.InotifyAddWatch(fd, directory, watchEvents)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %v", directory, err)
+		}
+		directories[int32(wd)] = directory
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeFile()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	pending := false
+	for {
+		n, err := file.Read(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if os.IsTimeout(err) {
+			pending = false
+			file.SetReadDeadline(time.Time{})
+			notify()
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read the configuration changes: %v", err)
+		}
+
+		if !pending && changed(buf[:n], directories, patterns) {
+			pending = true
+			file.SetReadDeadline(time.Now().Add(watchDebounce))
+		}
+	}
+}
+
+// changed reports if the inotify events refer to a file matching the patterns
+func changed(buf []byte, directories map[int32]string, patterns []string) bool {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		offset = nameStart + int(event.Len)
+
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			return true
+		}
+
+		if offset > len(buf) {
+			break
+		}
+
+		name := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+		path := filepath.Join(directories[event.Wd], name)
+		for _, pattern := range patterns {
+			if match, _ := filepath.Match(pattern, path); match {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WatchTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func TestWatchTestSuite(t *testing.T) {
+	suite.Run(t, new(WatchTestSuite))
+}
+
+func (w *WatchTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "docker-firewall")
+	w.Require().NoError(err)
+	w.dir = dir
+
+	w.Require().NoError(os.Mkdir(filepath.Join(dir, "rules.d"), 0755))
+	w.Require().NoError(ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("config:\n"), 0644))
+}
+
+func (w *WatchTestSuite) TearDownTest() {
+	os.RemoveAll(w.dir)
+}
+
+func (w *WatchTestSuite) Test_Watch() {
+	notifications := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	patterns := []string{filepath.Join(w.dir, "config.yml"), filepath.Join(w.dir, "rules.d", "*.yml")}
+	go func() {
+		done <- Watch(ctx, patterns, func() {
+			notifications <- struct{}{}
+		})
+	}()
+	// let the watches be added
+	time.Sleep(50 * time.Millisecond)
+
+	w.Require().NoError(ioutil.WriteFile(filepath.Join(w.dir, "config.yml"), []byte("config:\n  rules:\n"), 0644))
+	w.receive(notifications, "config.yml written")
+
+	w.Require().NoError(ioutil.WriteFile(filepath.Join(w.dir, "config.yml.swp"), []byte("x"), 0644))
+	w.Require().NoError(ioutil.WriteFile(filepath.Join(w.dir, "rules.d", "README"), []byte("x"), 0644))
+	w.none(notifications)
+
+	// editors save to a temporary file renamed over the original one
+	w.Require().NoError(ioutil.WriteFile(filepath.Join(w.dir, "rules.d", ".web.tmp"), []byte("rules:\n"), 0644))
+	w.Require().NoError(os.Rename(filepath.Join(w.dir, "rules.d", ".web.tmp"), filepath.Join(w.dir, "rules.d", "web.yml")))
+	w.receive(notifications, "rules.d/web.yml created")
+	w.none(notifications)
+
+	cancel()
+	w.NoError(<-done)
+}
+
+func (w *WatchTestSuite) receive(notifications chan struct{}, msg string) {
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		w.Fail("no notification: " + msg)
+	}
+}
+
+func (w *WatchTestSuite) none(notifications chan struct{}) {
+	select {
+	case <-notifications:
+		w.Fail("unexpected notification")
+	case <-time.After(2 * watchDebounce):
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package config
+
+import (
+	"context"
+	"errors"
+)
+
+// Watch is only supported on linux
+func Watch(ctx context.Context, patterns []string, notify func()) error {
+	return errors.New("watching the configuration files is only supported on linux")
+}
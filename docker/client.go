@@ -0,0 +1,162 @@
+// Package docker is a minimal client of the Docker Engine API
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultSocket is the unix socket dockerd listens on
+const DefaultSocket = "/var/run/docker.sock"
+
+// Client talks to the Docker Engine API over a unix socket. It remembers
+// what it logged about the rules, to only log it again when it changes.
+type Client struct {
+	http *http.Client
+
+	mu            sync.Mutex
+	meshMatches   []MeshMatch
+	ignoredLabels map[string]bool
+}
+
+// NewClient returns a Client for the dockerd listening on the unix socket
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+// Event defines a message of the Docker events stream
+type Event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  Actor  `json:"Actor"`
+	Time   int64  `json:"time"`
+}
+
+// Actor defines the object an Event refers to
+type Actor struct {
+	ID         string            `json:"ID"`
+	Attributes map[string]string `json:"Attributes"`
+}
+
+// Port defines a port a container exposes, PublicPort is set when it is published
+type Port struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+// PortBinding defines a host port a container port is published on
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// ContainerJSON defines the parts of a container inspection used by docker-firewall
+type ContainerJSON struct {
+	ID              string `json:"Id"`
+	Name            string `json:"Name"`
+	NetworkSettings struct {
+		Ports map[string][]PortBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// PublishesPorts reports if the container publishes any port on the host
+func (c ContainerJSON) PublishesPorts() bool {
+	for _, bindings := range c.NetworkSettings.Ports {
+		if len(bindings) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Ping checks that dockerd answers
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.get(ctx, "/_ping", nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// InspectContainer returns the details of a container
+func (c *Client) InspectContainer(ctx context.Context, id string) (ContainerJSON, error) {
+	var container ContainerJSON
+	err := c.getJSON(ctx, "/containers/"+url.PathEscape(id)+"/json", nil, &container)
+
+	return container, err
+}
+
+// Events opens the events stream with the given filters. The stream is
+// closed when the context is done or the returned function is called.
+func (c *Client) Events(ctx context.Context, filters map[string][]string) (*json.Decoder, func() error, error) {
+	query := url.Values{}
+	if len(filters) > 0 {
+		encoded, err := json.Marshal(filters)
+		if err != nil {
+			return nil, nil, err
+		}
+		query.Set("filters", string(encoded))
+	}
+
+	resp, err := c.get(ctx, "/events", query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return json.NewDecoder(resp.Body), resp.Body.Close, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode the response of %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("docker API %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
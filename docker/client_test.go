@@ -0,0 +1,296 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientTestSuite struct {
+	suite.Suite
+	dir         string
+	server      *httptest.Server
+	client      *Client
+	connections chan int
+	streams     chan []Event
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientTestSuite))
+}
+
+func (c *ClientTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "docker-firewall")
+	c.Require().NoError(err)
+	c.dir = dir
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	c.Require().NoError(err)
+
+	c.connections = make(chan int, 10)
+	c.streams = make(chan []Event, 10)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	})
+	mux.HandleFunc("/containers/web/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Id":"web","Name":"/web","NetworkSettings":{"Ports":{"80/tcp":[{"HostIp":"0.0.0.0","HostPort":"8080"}]}}}`)
+	})
+	mux.HandleFunc("/containers/worker/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Id":"worker","Name":"/worker","NetworkSettings":{"Ports":{"9000/tcp":null}}}`)
+	})
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Id":"web","Names":["/web"],"Labels":{"docker-firewall.allow":"10.0.0.0/8, 192.168.1.15"},"Ports":[
+				{"IP":"0.0.0.0","PrivatePort":80,"PublicPort":8080,"Type":"tcp"},
+				{"IP":"::","PrivatePort":80,"PublicPort":8080,"Type":"tcp"},
+				{"PrivatePort":443,"Type":"tcp"}
+			]},
+			{"Id":"worker","Names":["/worker"],"Labels":{},"Ports":[{"IP":"0.0.0.0","PrivatePort":9000,"PublicPort":9000,"Type":"tcp"}]}
+		]`)
+	})
+	mux.HandleFunc("/services", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"ID":"s1","Spec":{"Name":"web"},"Endpoint":{"Ports":[
+				{"Protocol":"tcp","TargetPort":80,"PublishedPort":3000,"PublishMode":"ingress"},
+				{"Protocol":"tcp","TargetPort":443,"PublishedPort":4443,"PublishMode":"host"}
+			]}},
+			{"ID":"s2","Spec":{"Name":"worker"},"Endpoint":{}}
+		]`)
+	})
+	connection := 0
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		c.NoError(json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters))
+		c.Equal(eventFilters, filters)
+
+		connection++
+		c.connections <- connection
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		select {
+		case events := <-c.streams:
+			encoder := json.NewEncoder(w)
+			for _, event := range events {
+				encoder.Encode(event)
+			}
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+		}
+	})
+
+	c.server = httptest.NewUnstartedServer(mux)
+	c.server.Listener = listener
+	c.server.Start()
+
+	c.client = NewClient(socket)
+	minReconnectDelay = 10 * time.Millisecond
+}
+
+func (c *ClientTestSuite) TearDownTest() {
+	c.server.Close()
+	os.RemoveAll(c.dir)
+}
+
+func (c *ClientTestSuite) Test_Ping() {
+	c.NoError(c.client.Ping(context.Background()))
+	c.Error(NewClient(filepath.Join(c.dir, "missing.sock")).Ping(context.Background()))
+}
+
+func (c *ClientTestSuite) Test_InspectContainer() {
+	container, err := c.client.InspectContainer(context.Background(), "web")
+	c.NoError(err)
+	c.Equal("/web", container.Name)
+	c.True(container.PublishesPorts())
+
+	container, err = c.client.InspectContainer(context.Background(), "worker")
+	c.NoError(err)
+	c.False(container.PublishesPorts())
+
+	_, err = c.client.InspectContainer(context.Background(), "missing")
+	c.EqualError(err, "docker API /containers/missing/json returned 404 Not Found: 404 page not found")
+}
+
+func (c *ClientTestSuite) Test_LabelRules() {
+	rules, ignored, err := c.client.LabelRules(context.Background())
+	c.NoError(err)
+	c.Empty(ignored)
+
+	expected := []config.Rule{
+		{Protocol: "tcp", Port: 8080, OriginalPort: true, Allow: []string{"10.0.0.0/8", "192.168.1.15"}},
+	}
+	c.Equal(expected, rules)
+}
+
+func (c *ClientTestSuite) Test_RulesFromContainers() {
+	containers := []Container{
+		{
+			ID:     "dns",
+			Labels: map[string]string{AllowLabel: "10.0.0.5-10.0.0.50"},
+			Ports: []Port{
+				{IP: "0.0.0.0", PrivatePort: 53, PublicPort: 5353, Type: "tcp"},
+				{IP: "0.0.0.0", PrivatePort: 53, PublicPort: 5353, Type: "udp"},
+			},
+		},
+		{
+			ID:     "invalid",
+			Labels: map[string]string{AllowLabel: "10.0.0.300"},
+			Ports:  []Port{{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 80, Type: "tcp"}},
+		},
+		{
+			ID:     "empty",
+			Labels: map[string]string{AllowLabel: " , "},
+			Ports:  []Port{{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 81, Type: "tcp"}},
+		},
+	}
+
+	expected := []config.Rule{
+		{Protocol: "tcp", Port: 5353, OriginalPort: true, Allow: []string{"10.0.0.5-10.0.0.50"}},
+		{Protocol: "udp", Port: 5353, OriginalPort: true, Allow: []string{"10.0.0.5-10.0.0.50"}},
+	}
+	rules, ignored := RulesFromContainers(containers)
+	c.Equal(expected, rules)
+	c.Require().Len(ignored, 2)
+	c.Contains(ignored[0], `the docker-firewall.allow label "10.0.0.300" of container invalid: `)
+	c.Equal("the empty docker-firewall.allow label of container empty", ignored[1])
+}
+
+func (c *ClientTestSuite) Test_LogIgnoredLabels() {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	c.client.logIgnoredLabels([]string{"the empty docker-firewall.allow label of container empty"})
+	c.client.logIgnoredLabels([]string{"the empty docker-firewall.allow label of container empty"})
+	c.Equal(1, strings.Count(output.String(), "Ignoring the empty docker-firewall.allow label of container empty"))
+
+	c.client.logIgnoredLabels(nil)
+	c.client.logIgnoredLabels([]string{"the empty docker-firewall.allow label of container empty"})
+	c.Equal(2, strings.Count(output.String(), "Ignoring the empty docker-firewall.allow label of container empty"))
+}
+
+func (c *ClientTestSuite) Test_SwarmRules() {
+	rules := []config.Rule{
+		{Protocol: "tcp", Port: 3000, Allow: []string{"10.0.0.0/8"}},
+		{Ports: []config.PortRange{{From: 2990, To: 3010}}},
+		{Protocol: "udp", Port: 3000},
+		{Protocol: "tcp", Port: 4443},
+		{Protocol: "tcp", Port: 22, Ports: []config.PortRange{{From: 3000, To: 3000}, {From: 8080, To: 8080}}},
+	}
+
+	rules, err := c.client.SwarmRules(context.Background(), rules)
+	c.NoError(err)
+
+	expected := []config.Rule{
+		{Protocol: "tcp", Port: 3000, OriginalPort: true, Allow: []string{"10.0.0.0/8"}},
+		{Port: 3000, OriginalPort: true},
+		{Ports: []config.PortRange{{From: 2990, To: 2999}, {From: 3001, To: 3010}}},
+		{Protocol: "udp", Port: 3000},
+		{Protocol: "tcp", Port: 4443},
+		{Protocol: "tcp", Port: 3000, OriginalPort: true},
+		{Protocol: "tcp", Port: 22, Ports: []config.PortRange{{From: 8080, To: 8080}}},
+	}
+	c.Equal(expected, rules)
+}
+
+func (c *ClientTestSuite) Test_MeshRules() {
+	ports := []MeshPort{
+		{Service: "web", Protocol: "tcp", PublishedPort: 80, TargetPort: 8080},
+		{Service: "api", Protocol: "tcp", PublishedPort: 90, TargetPort: 9090},
+	}
+	rules := []config.Rule{
+		{Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 90}}, Allow: []string{"10.0.0.0/8"}},
+		{Protocol: "tcp", Port: 80, OriginalPort: true},
+	}
+
+	meshRules, matches := MeshRules(rules, ports)
+	c.Equal([]config.Rule{
+		{Protocol: "tcp", Ports: []config.PortRange{{From: 80, To: 80}, {From: 90, To: 90}}, OriginalPort: true, Allow: []string{"10.0.0.0/8"}},
+		{Protocol: "tcp", Ports: []config.PortRange{{From: 81, To: 89}}, Allow: []string{"10.0.0.0/8"}},
+		{Protocol: "tcp", Port: 80, OriginalPort: true},
+	}, meshRules)
+	c.Equal([]MeshMatch{{Rule: 0, Port: ports[0]}, {Rule: 0, Port: ports[1]}}, matches)
+}
+
+func (c *ClientTestSuite) Test_SwarmRules_LogsChanges() {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	rules := []config.Rule{{Protocol: "tcp", Port: 3000}}
+	for i := 0; i < 2; i++ {
+		_, err := c.client.SwarmRules(context.Background(), rules)
+		c.NoError(err)
+	}
+	c.Equal(1, strings.Count(output.String(), "Rule 0 port 3000 is published by service web"))
+
+	_, err := c.client.SwarmRules(context.Background(), []config.Rule{{Protocol: "tcp", Port: 22}, rules[0]})
+	c.NoError(err)
+	c.Contains(output.String(), "Rule 1 port 3000 is published by service web")
+}
+
+func (c *ClientTestSuite) Test_Watch() {
+	notifications := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- c.client.Watch(ctx, func() {
+			notifications <- struct{}{}
+		})
+	}()
+
+	c.Equal(1, <-c.connections)
+	c.streams <- []Event{
+		{Type: "container", Action: "start", Actor: Actor{ID: "worker"}},
+		{Type: "container", Action: "start", Actor: Actor{ID: "web"}},
+		{Type: "network", Action: "create", Actor: Actor{ID: "backend"}},
+		{Type: "network", Action: "connect", Actor: Actor{ID: "backend"}},
+		{Type: "container", Action: "die", Actor: Actor{ID: "worker"}},
+		{Type: "container", Action: "die", Actor: Actor{ID: "web", Attributes: map[string]string{AllowLabel: "10.0.0.0/8"}}},
+		{Type: "service", Action: "update", Actor: Actor{ID: "s1"}},
+	}
+
+	c.receive(notifications, "container web publishing ports started")
+	c.receive(notifications, "network backend created")
+	c.receive(notifications, "container web with label stopped")
+	c.receive(notifications, "service s1 updated")
+
+	// the stream ends as when dockerd stops, connecting again means it started
+	c.Equal(2, <-c.connections)
+	c.receive(notifications, "dockerd started")
+
+	select {
+	case <-notifications:
+		c.Fail("unexpected notification")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	c.NoError(<-done)
+}
+
+func (c *ClientTestSuite) receive(notifications chan struct{}, msg string) {
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		c.FailNow("missing notification", msg)
+	}
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// eventFilters selects the events that can require the rules to be applied again
+var eventFilters = map[string][]string{
+	"type":  {"container", "network", "service"},
+	"event": {"start", "die", "create", "destroy", "update", "remove"},
+}
+
+// minReconnectDelay is the delay before connecting again to the events stream,
+// doubled on each failure up to maxReconnectDelay
+var minReconnectDelay = time.Second
+
+// maxReconnectDelay caps the delay between two connections to the events stream
+const maxReconnectDelay = 30 * time.Second
+
+// Watch follows the Docker events until the context is done, and calls
+// notify when dockerd starts, a network is created or destroyed, a container
+// publishing ports starts, a container with an AllowLabel stops, or a Swarm
+// service changes. dockerd recreates DOCKER-USER when it
+// starts, which is detected when the events stream connects again.
+func (c *Client) Watch(ctx context.Context, notify func()) error {
+	delay := minReconnectDelay
+	connected := false
+
+	for {
+		err := c.watchEvents(ctx, func() {
+			if connected {
+				log.Println("Connected to dockerd again")
+				notify()
+			}
+			connected = true
+			delay = minReconnectDelay
+		}, notify)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Printf("Lost the docker events stream, reconnecting in %s: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (c *Client) watchEvents(ctx context.Context, connected, notify func()) error {
+	decoder, closeStream, err := c.Events(ctx, eventFilters)
+	if err != nil {
+		return err
+	}
+	defer closeStream()
+
+	connected()
+
+	for {
+		var event Event
+		err := decoder.Decode(&event)
+		if err != nil {
+			return err
+		}
+
+		if c.requiresApply(ctx, event) {
+			log.Printf("Docker %s %s %s", event.Type, event.Action, event.Actor.ID)
+			notify()
+		}
+	}
+}
+
+func (c *Client) requiresApply(ctx context.Context, event Event) bool {
+	switch {
+	case event.Type == "network" && (event.Action == "create" || event.Action == "destroy"):
+		return true
+	case event.Type == "service":
+		return true
+	case event.Type == "container" && event.Action == "die":
+		_, ok := event.Actor.Attributes[AllowLabel]
+		return ok
+	case event.Type == "container" && event.Action == "start":
+		container, err := c.InspectContainer(ctx, event.Actor.ID)
+		if err != nil {
+			log.Printf("Failed to inspect container %s: %v", event.Actor.ID, err)
+			return true
+		}
+		return container.PublishesPorts()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// AllowLabel is the container label listing, comma separated, the addresses
+// allowed to reach the ports the container publishes
+const AllowLabel = "docker-firewall.allow"
+
+// Container defines a container as listed by the Docker API
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []Port            `json:"Ports"`
+}
+
+// ListContainers returns the running containers
+func (c *Client) ListContainers(ctx context.Context) ([]Container, error) {
+	var containers []Container
+	err := c.getJSON(ctx, "/containers/json", nil, &containers)
+
+	return containers, err
+}
+
+// LabelRules returns the rules declared by the labels of the running
+// containers, and the labels ignored. An ignored label is only logged the
+// first time it is seen.
+func (c *Client) LabelRules(ctx context.Context) ([]config.Rule, []string, error) {
+	containers, err := c.ListContainers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rules, ignored := RulesFromContainers(containers)
+	c.logIgnoredLabels(ignored)
+
+	return rules, ignored, nil
+}
+
+// logIgnoredLabels logs the labels ignored that were not the last time
+func (c *Client) logIgnoredLabels(ignored []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reported := map[string]bool{}
+	for _, label := range ignored {
+		if !c.ignoredLabels[label] {
+			log.Printf("Ignoring %s", label)
+		}
+		reported[label] = true
+	}
+	c.ignoredLabels = reported
+}
+
+// RulesFromContainers converts the AllowLabel of the containers into rules
+// targeting their published ports. DOCKER-USER sees the packets after they
+// were translated to the container port, so the rules match the original
+// destination port. Labels with invalid addresses are skipped, and returned
+// as the reason they were ignored.
+func RulesFromContainers(containers []Container) ([]config.Rule, []string) {
+	rules := []config.Rule{}
+	ignored := []string{}
+
+	for _, container := range containers {
+		label, ok := container.Labels[AllowLabel]
+		if !ok {
+			continue
+		}
+
+		allow := []string{}
+		for _, entry := range strings.Split(label, ",") {
+			if strings.TrimSpace(entry) != "" {
+				allow = append(allow, strings.TrimSpace(entry))
+			}
+		}
+
+		if len(allow) == 0 {
+			ignored = append(ignored, fmt.Sprintf("the empty %s label of container %s", AllowLabel, container.ID))
+			continue
+		}
+
+		err := config.Rule{Allow: allow}.Validate()
+		if err != nil {
+			ignored = append(ignored, fmt.Sprintf("the %s label %q of container %s: %v", AllowLabel, label, container.ID, err))
+			continue
+		}
+
+		published := map[Port]bool{}
+		for _, port := range container.Ports {
+			if port.PublicPort == 0 {
+				continue
+			}
+
+			// the same port is listed once per published address family
+			key := Port{PublicPort: port.PublicPort, Type: port.Type}
+			if published[key] {
+				continue
+			}
+			published[key] = true
+
+			rules = append(rules, config.Rule{
+				Protocol:     port.Type,
+				Port:         port.PublicPort,
+				OriginalPort: true,
+				Allow:        allow,
+			})
+		}
+	}
+
+	return rules, ignored
+}
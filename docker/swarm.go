@@ -0,0 +1,208 @@
+package docker
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Service defines a Swarm service as listed by the Docker API
+type Service struct {
+	ID   string `json:"ID"`
+	Spec struct {
+		Name string `json:"Name"`
+	} `json:"Spec"`
+	Endpoint struct {
+		Ports []ServicePort `json:"Ports"`
+	} `json:"Endpoint"`
+}
+
+// ServicePort defines a port published by a Swarm service
+type ServicePort struct {
+	Protocol      string `json:"Protocol"`
+	TargetPort    int    `json:"TargetPort"`
+	PublishedPort int    `json:"PublishedPort"`
+	PublishMode   string `json:"PublishMode"`
+}
+
+// MeshPort defines a port published through the ingress routing mesh,
+// traffic to PublishedPort is forwarded to TargetPort of the service tasks
+type MeshPort struct {
+	Service       string
+	Protocol      string
+	PublishedPort int
+	TargetPort    int
+}
+
+// ListServices returns the Swarm services
+func (c *Client) ListServices(ctx context.Context) ([]Service, error) {
+	var services []Service
+	err := c.getJSON(ctx, "/services", nil, &services)
+
+	return services, err
+}
+
+// MeshPorts returns the ports the services publish through the ingress routing mesh
+func MeshPorts(services []Service) []MeshPort {
+	ports := []MeshPort{}
+
+	for _, service := range services {
+		for _, port := range service.Endpoint.Ports {
+			if port.PublishedPort == 0 || port.PublishMode == "host" {
+				continue
+			}
+
+			ports = append(ports, MeshPort{
+				Service:       service.Spec.Name,
+				Protocol:      port.Protocol,
+				PublishedPort: port.PublishedPort,
+				TargetPort:    port.TargetPort,
+			})
+		}
+	}
+
+	return ports
+}
+
+// MeshMatch defines a port of a rule published through the ingress routing mesh
+type MeshMatch struct {
+	Rule int
+	Port MeshPort
+}
+
+// MeshRules returns the rules with the ports published through the ingress
+// routing mesh split into a rule matching the original destination port, as
+// the destination port seen in DOCKER-USER is no longer the published one.
+// The other ports of the rules are left unchanged. It also returns the ports
+// of the rules it split.
+func MeshRules(rules []config.Rule, ports []MeshPort) ([]config.Rule, []MeshMatch) {
+	meshRules := make([]config.Rule, 0, len(rules))
+	matches := []MeshMatch{}
+
+	for i, rule := range rules {
+		published := []int{}
+		if !rule.OriginalPort {
+			for _, mesh := range ports {
+				if meshPortMatches(rule, mesh) {
+					matches = append(matches, MeshMatch{Rule: i, Port: mesh})
+					published = append(published, mesh.PublishedPort)
+				}
+			}
+		}
+
+		if len(published) == 0 {
+			meshRules = append(meshRules, rule)
+			continue
+		}
+
+		published = uniquePorts(published)
+		meshRule := rule
+		meshRule.Port = 0
+		meshRule.Ports = nil
+		meshRule.OriginalPort = true
+		if len(published) == 1 {
+			meshRule.Port = published[0]
+		} else {
+			for _, port := range published {
+				meshRule.Ports = append(meshRule.Ports, config.PortRange{From: port, To: port})
+			}
+		}
+		meshRules = append(meshRules, meshRule)
+
+		if rule.Port != 0 && len(excludePorts([]config.PortRange{{From: rule.Port, To: rule.Port}}, published)) == 0 {
+			rule.Port = 0
+		}
+		rule.Ports = excludePorts(rule.Ports, published)
+		if rule.Port != 0 || len(rule.Ports) > 0 {
+			meshRules = append(meshRules, rule)
+		}
+	}
+
+	return meshRules, matches
+}
+
+func meshPortMatches(rule config.Rule, mesh MeshPort) bool {
+	if rule.Protocol != "" && rule.Protocol != mesh.Protocol {
+		return false
+	}
+
+	for _, port := range rule.PortRanges() {
+		if port.From <= mesh.PublishedPort && mesh.PublishedPort <= port.To {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uniquePorts returns the ports sorted, without duplicates
+func uniquePorts(ports []int) []int {
+	sort.Ints(ports)
+
+	unique := []int{}
+	for _, port := range ports {
+		if len(unique) == 0 || unique[len(unique)-1] != port {
+			unique = append(unique, port)
+		}
+	}
+
+	return unique
+}
+
+// excludePorts returns the port ranges without the sorted ports, a range
+// being split around the ports it contains
+func excludePorts(ranges []config.PortRange, ports []int) []config.PortRange {
+	var remaining []config.PortRange
+
+	for _, portRange := range ranges {
+		from := portRange.From
+		for _, port := range ports {
+			if port < from || port > portRange.To {
+				continue
+			}
+			if port > from {
+				remaining = append(remaining, config.PortRange{From: from, To: port - 1})
+			}
+			from = port + 1
+		}
+
+		if from <= portRange.To {
+			remaining = append(remaining, config.PortRange{From: from, To: portRange.To})
+		}
+	}
+
+	return remaining
+}
+
+// SwarmRules returns the rules adapted to the ports the Swarm services
+// publish through the ingress routing mesh
+func (c *Client) SwarmRules(ctx context.Context, rules []config.Rule) ([]config.Rule, error) {
+	services, err := c.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meshRules, matches := MeshRules(rules, MeshPorts(services))
+	c.logMeshMatches(matches)
+
+	return meshRules, nil
+}
+
+// logMeshMatches logs the ports of the rules published through the ingress
+// routing mesh when they changed since the last time
+func (c *Client) logMeshMatches(matches []MeshMatch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reflect.DeepEqual(matches, c.meshMatches) {
+		return
+	}
+	c.meshMatches = matches
+
+	for _, match := range matches {
+		log.Printf("Rule %d port %d is published by service %s to port %d, matching the original destination port", match.Rule, match.Port.PublishedPort, match.Port.Service, match.Port.TargetPort)
+	}
+}
@@ -0,0 +1,140 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// Backend defines the operations the Firewall uses to program the kernel
+type Backend interface {
+	Insert(table, chain string, pos int, rulespec ...string) error
+	Append(table, chain string, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	List(table, chain string) ([]string, error)
+	ClearChain(table, chain string) error
+	ChainExists(table, chain string) (bool, error)
+	ReplaceChain(table, chain string, rules [][]string) error
+}
+
+// CompiledBackend is implemented by the backends programming the compiled
+// rules in their own syntax, their iptables rule specs only identifying them
+type CompiledBackend interface {
+	ReplaceCompiled(table, chain string, rules []compiler.Rule) error
+}
+
+// IPTables is the default Backend, backed by coreos/go-iptables
+type IPTables struct {
+	ipt   *iptables.IPTables
+	proto iptables.Protocol
+}
+
+// NewIPTables returns an IPTables backend for the given protocol
+func NewIPTables(proto iptables.Protocol) (*IPTables, error) {
+	ipt, err := iptables.NewWithProtocol(proto)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPTables{ipt: ipt, proto: proto}, nil
+}
+
+// Insert inserts rulespec to specified table/chain in the specified position
+func (i *IPTables) Insert(table, chain string, pos int, rulespec ...string) error {
+	return i.ipt.Insert(table, chain, pos, rulespec...)
+}
+
+// Append appends rulespec to specified table/chain
+func (i *IPTables) Append(table, chain string, rulespec ...string) error {
+	return i.ipt.Append(table, chain, rulespec...)
+}
+
+// Delete removes rulespec in specified table/chain
+func (i *IPTables) Delete(table, chain string, rulespec ...string) error {
+	return i.ipt.Delete(table, chain, rulespec...)
+}
+
+// Exists checks if given rulespec in specified table/chain exists
+func (i *IPTables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	return i.ipt.Exists(table, chain, rulespec...)
+}
+
+// List rules in specified table/chain
+func (i *IPTables) List(table, chain string) ([]string, error) {
+	return i.ipt.List(table, chain)
+}
+
+// ClearChain flushes the chain in the specified table, creating it if it does not exist
+func (i *IPTables) ClearChain(table, chain string) error {
+	return i.ipt.ClearChain(table, chain)
+}
+
+// ChainExists checks if the chain exists in the specified table
+func (i *IPTables) ChainExists(table, chain string) (bool, error) {
+	chains, err := i.ipt.ListChains(table)
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range chains {
+		if c == chain {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ReplaceChain atomically replaces the content of the chain with the given
+// rules in a single iptables-restore --noflush transaction
+func (i *IPTables) ReplaceChain(table, chain string, rules [][]string) error {
+	command := "iptables-restore"
+	if i.proto == iptables.ProtocolIPv6 {
+		command = "ip6tables-restore"
+	}
+
+	cmd := exec.Command(command, "--noflush")
+	cmd.Stdin = strings.NewReader(renderRestore(table, chain, rules))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("running %s failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// renderRestore renders the iptables-restore input replacing the chain content.
+// Declaring the chain flushes it even when --noflush is used.
+func renderRestore(table, chain string, rules [][]string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%s\n", table)
+	fmt.Fprintf(&b, ":%s - [0:0]\n", chain)
+	for _, rule := range rules {
+		args := make([]string, 0, len(rule))
+		for _, arg := range rule {
+			args = append(args, quoteRestoreArg(arg))
+		}
+		fmt.Fprintf(&b, "-A %s %s\n", chain, strings.Join(args, " "))
+	}
+	b.WriteString("COMMIT\n")
+
+	return b.String()
+}
+
+func quoteRestoreArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+
+	return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+}
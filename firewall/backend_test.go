@@ -0,0 +1,61 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type BackendTestSuite struct {
+	suite.Suite
+}
+
+func TestBackendTestSuite(t *testing.T) {
+	suite.Run(t, new(BackendTestSuite))
+}
+
+func (b *BackendTestSuite) Test_RenderRestore() {
+	rules := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.1.1.1", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-m", "comment", "--comment", `allow "office"`, "-j", "RETURN"},
+		{"-j", "DROP"},
+	}
+
+	expected := `*filter
+:DOCKER-USER - [0:0]
+-A DOCKER-USER -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN
+-A DOCKER-USER -s 10.1.1.1 -p tcp -m tcp --dport 8080 -j RETURN
+-A DOCKER-USER -m comment --comment "allow \"office\"" -j RETURN
+-A DOCKER-USER -j DROP
+COMMIT
+`
+
+	b.Equal(expected, renderRestore(FilterTable, DockerUserChain, rules))
+}
+
+func (b *BackendTestSuite) Test_RenderRestore_EmptyChain() {
+	b.Equal("*filter\n:DOCKER-USER - [0:0]\nCOMMIT\n", renderRestore(FilterTable, DockerUserChain, nil))
+}
+
+func (b *BackendTestSuite) Test_RenderIPSetRestore() {
+	network, _ := config.ParseAddress("10.0.0.0/8")
+	addresses, _ := config.ParseAddress("192.168.1.10-192.168.1.20")
+	ipv6, _ := config.ParseAddress("2001:db8::/32")
+
+	sets := []compiler.Set{
+		{Name: "docker-firewall-v4-0", Family: compiler.IPv4, Entries: []config.Address{network, addresses}},
+		{Name: "docker-firewall-v6-0", Family: compiler.IPv6, Entries: []config.Address{ipv6}},
+	}
+
+	expected := `create docker-firewall-v4-0 hash:net family inet -exist
+add docker-firewall-v4-0 10.0.0.0/8 -exist
+add docker-firewall-v4-0 192.168.1.10-192.168.1.20 -exist
+create docker-firewall-v6-0 hash:net family inet6 -exist
+add docker-firewall-v6-0 2001:db8::/32 -exist
+`
+
+	b.Equal(expected, renderIPSetRestore(sets))
+}
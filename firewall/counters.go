@@ -0,0 +1,153 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// Counter defines the packets and bytes matched by a rule
+type Counter struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// CounterBackend is implemented by the backends able to read the counters of
+// the rules of a chain, in the order of the chain
+type CounterBackend interface {
+	Counters(table, chain string) ([]Counter, error)
+}
+
+// EstablishedRuleIndex is the RuleCounter index of the rule letting the
+// replies of allowed connections through
+const EstablishedRuleIndex = compiler.EstablishedOrigin
+
+// DefaultRuleIndex is the RuleCounter index of the rule terminating the chain
+const DefaultRuleIndex = compiler.DefaultOrigin
+
+// DefaultLogRuleIndex is the RuleCounter index of the rule logging the
+// traffic reaching the end of the chain
+const DefaultLogRuleIndex = compiler.DefaultLogOrigin
+
+// RuleCounter defines the counters of the rules rendered for a configuration
+// rule in an IP protocol
+type RuleCounter struct {
+	Family string
+	// Rule is the index of the configuration rule, or EstablishedRuleIndex,
+	// DefaultLogRuleIndex and DefaultRuleIndex for the rules added by
+	// docker-firewall
+	Rule int
+	Name string
+	Counter
+}
+
+// Counters returns the counters of the DOCKER-FIREWALL rules, summed by the
+// configuration rule they were rendered from. Backends that can not read
+// counters, and the IP protocols Apply skips, are skipped.
+func (f *Firewall) Counters(rules []config.Rule) ([]RuleCounter, error) {
+	result := []RuleCounter{}
+
+	for _, family := range f.families {
+		backend, ok := family.backend.(CounterBackend)
+		if !ok {
+			continue
+		}
+
+		hooked, err := hooked(family.backend)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hooked {
+			continue
+		}
+
+		counters, err := backend.Counters(FilterTable, FirewallChain)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the %s counters: %v", protocolName(family.proto), err)
+		}
+
+		entries := f.chainEntries(rules, family.proto)
+		if len(counters) != len(entries) {
+			return nil, fmt.Errorf("%s %s holds %d rules instead of %d", protocolName(family.proto), FirewallChain, len(counters), len(entries))
+		}
+
+		byRule := map[int]int{}
+		for i, entry := range entries {
+			position, ok := byRule[entry.rule]
+			if !ok {
+				position = len(result)
+				byRule[entry.rule] = position
+
+				name := ""
+				if entry.rule >= 0 {
+					name = rules[entry.rule].Name
+				}
+				result = append(result, RuleCounter{Family: protocolName(family.proto), Rule: entry.rule, Name: name})
+			}
+
+			result[position].Packets += counters[i].Packets
+			result[position].Bytes += counters[i].Bytes
+		}
+	}
+
+	return result, nil
+}
+
+// Counters reads the counters of the rules of the chain with iptables -L -v -x
+func (i *IPTables) Counters(table, chain string) ([]Counter, error) {
+	command := "iptables"
+	if i.proto == iptables.ProtocolIPv6 {
+		command = "ip6tables"
+	}
+
+	cmd := exec.Command(command, "-w", "-t", table, "-L", chain, "-v", "-x", "-n")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("running %s failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseCounters(stdout.String())
+}
+
+// parseCounters parses the output of iptables -L CHAIN -v -x -n, returning
+// the counters of each rule in order
+func parseCounters(output string) ([]Counter, error) {
+	counters := []Counter{}
+
+	for n, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "Chain" || fields[0] == "pkts" {
+			continue
+		}
+
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: unexpected rule %q", n+1, line)
+		}
+
+		packets, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid packet counter %q, counters must be listed exactly with -x", n+1, fields[0])
+		}
+
+		bytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid byte counter %q, counters must be listed exactly with -x", n+1, fields[1])
+		}
+
+		counters = append(counters, Counter{Packets: packets, Bytes: bytes})
+	}
+
+	return counters, nil
+}
@@ -0,0 +1,117 @@
+package firewall
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/firewall/fake"
+	"github.com/stretchr/testify/suite"
+)
+
+// countingBackend is a fake backend listing the counters of a captured
+// iptables -L -v -x output
+type countingBackend struct {
+	*fake.Backend
+	fixture string
+}
+
+func (c countingBackend) Counters(table, chain string) ([]Counter, error) {
+	output, err := ioutil.ReadFile(c.fixture)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCounters(string(output))
+}
+
+type CountersTestSuite struct {
+	suite.Suite
+	rules []config.Rule
+}
+
+func TestCountersTestSuite(t *testing.T) {
+	suite.Run(t, new(CountersTestSuite))
+}
+
+func (c *CountersTestSuite) SetupTest() {
+	c.rules = []config.Rule{
+		{
+			Name:      "api",
+			Interface: []string{"eth0", "eth1"},
+			Protocol:  "tcp",
+			Port:      8080,
+			Allow:     []string{"10.1.1.1", "fd00::1"},
+		},
+		{
+			Protocol: "tcp",
+			Ports:    []config.PortRange{{From: 22, To: 22}, {From: 80, To: 90}},
+			Allow:    []string{"10.0.0.0/8"},
+		},
+	}
+}
+
+func (c *CountersTestSuite) Test_ParseCounters() {
+	output, err := ioutil.ReadFile("testdata/iptables-counters.txt")
+	c.Require().NoError(err)
+
+	counters, err := parseCounters(string(output))
+	c.NoError(err)
+
+	expected := []Counter{
+		{Packets: 1520, Bytes: 198745},
+		{Packets: 12, Bytes: 720},
+		{Packets: 3, Bytes: 180},
+		{Packets: 0, Bytes: 0},
+		{Packets: 845, Bytes: 50700},
+	}
+	c.Equal(expected, counters)
+
+	counters, err = parseCounters("Chain DOCKER-FIREWALL (0 references)\n    pkts      bytes target     prot opt in     out     source               destination\n")
+	c.NoError(err)
+	c.Empty(counters)
+}
+
+func (c *CountersTestSuite) Test_ParseCounters_Invalid() {
+	_, err := parseCounters("Chain DOCKER-FIREWALL (1 references)\n    1520     194K RETURN     all  --  *      *       0.0.0.0/0            0.0.0.0/0\n")
+	c.EqualError(err, `line 2: invalid byte counter "194K", counters must be listed exactly with -x`)
+
+	_, err = parseCounters("Chain DOCKER-FIREWALL (1 references)\n    1520\n")
+	c.EqualError(err, `line 2: unexpected rule "    1520"`)
+}
+
+func (c *CountersTestSuite) Test_Counters() {
+	firewall := NewFirewallWithBackends(
+		countingBackend{Backend: fake.New(), fixture: "testdata/iptables-counters.txt"},
+		countingBackend{Backend: fake.New(), fixture: "testdata/ip6tables-counters.txt"},
+	)
+
+	counters, err := firewall.Counters(c.rules)
+	c.NoError(err)
+
+	expected := []RuleCounter{
+		{Family: "IPv4", Rule: EstablishedRuleIndex, Counter: Counter{Packets: 1520, Bytes: 198745}},
+		{Family: "IPv4", Rule: 0, Name: "api", Counter: Counter{Packets: 15, Bytes: 900}},
+		{Family: "IPv4", Rule: 1, Counter: Counter{Packets: 0, Bytes: 0}},
+		{Family: "IPv4", Rule: DefaultRuleIndex, Counter: Counter{Packets: 845, Bytes: 50700}},
+		{Family: "IPv6", Rule: EstablishedRuleIndex, Counter: Counter{Packets: 40, Bytes: 3200}},
+		{Family: "IPv6", Rule: 0, Name: "api", Counter: Counter{Packets: 8, Bytes: 640}},
+		{Family: "IPv6", Rule: DefaultRuleIndex, Counter: Counter{Packets: 2, Bytes: 160}},
+	}
+	c.Equal(expected, counters)
+}
+
+func (c *CountersTestSuite) Test_Counters_Drift() {
+	firewall := NewFirewallWithBackend(countingBackend{Backend: fake.New(), fixture: "testdata/iptables-counters.txt"})
+
+	_, err := firewall.Counters(c.rules[:1])
+	c.EqualError(err, "IPv4 DOCKER-FIREWALL holds 5 rules instead of 4")
+}
+
+func (c *CountersTestSuite) Test_Counters_Unsupported() {
+	firewall := NewFirewallWithBackend(fake.New())
+
+	counters, err := firewall.Counters(c.rules)
+	c.NoError(err)
+	c.Empty(counters)
+}
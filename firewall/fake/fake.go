@@ -0,0 +1,276 @@
+// Package fake provides an in-memory firewall backend for tests
+package fake
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+)
+
+// Backend is an in-memory firewall backend. It models tables, chains and the
+// order of the rules, and matches rules by an exact comparison of their
+// arguments, so rules iptables -C would find equal, such as the same matches
+// in another order, do not match. It also holds the entries of the ipsets.
+type Backend struct {
+	mu     sync.Mutex
+	tables map[string]map[string][][]string
+	sets   map[string][]string
+}
+
+// New returns a Backend with the filter table holding a DOCKER-USER chain
+// and the FORWARD jump to it, as created by dockerd
+func New() *Backend {
+	b := &Backend{tables: map[string]map[string][][]string{}, sets: map[string][]string{}}
+	b.NewChain("filter", "DOCKER-USER")
+	b.tables["filter"]["DOCKER-USER"] = [][]string{{"-j", "RETURN"}}
+	b.NewChain("filter", "FORWARD")
+	b.tables["filter"]["FORWARD"] = [][]string{{"-j", "DOCKER-USER"}}
+
+	return b
+}
+
+// NewChain creates an empty chain in the specified table
+func (b *Backend) NewChain(table, chain string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.tables[table][chain]; ok {
+		return fmt.Errorf("chain %s already exists in table %s", chain, table)
+	}
+
+	b.chains(table)[chain] = [][]string{}
+
+	return nil
+}
+
+// Insert inserts rulespec to specified table/chain in the specified position
+func (b *Backend) Insert(table, chain string, pos int, rulespec ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules, err := b.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("index of insertion too big: %d", pos)
+	}
+
+	rules = append(rules, nil)
+	copy(rules[pos:], rules[pos-1:])
+	rules[pos-1] = copyRule(rulespec)
+	b.tables[table][chain] = rules
+
+	return nil
+}
+
+// Append appends rulespec to specified table/chain
+func (b *Backend) Append(table, chain string, rulespec ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules, err := b.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	b.tables[table][chain] = append(rules, copyRule(rulespec))
+
+	return nil
+}
+
+// Delete removes the first rule matching rulespec in specified table/chain
+func (b *Backend) Delete(table, chain string, rulespec ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules, err := b.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	for i, rule := range rules {
+		if equal(rule, rulespec) {
+			b.tables[table][chain] = append(rules[:i:i], rules[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("bad rule (does a matching rule exist in that chain?): %v", rulespec)
+}
+
+// Exists checks if given rulespec in specified table/chain exists
+func (b *Backend) Exists(table, chain string, rulespec ...string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules, err := b.chain(table, chain)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rule := range rules {
+		if equal(rule, rulespec) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// List rules in specified table/chain, in the same format as iptables -S
+func (b *Backend) List(table, chain string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules, err := b.chain(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{"-N " + chain}
+	for _, rule := range rules {
+		list = append(list, "-A "+chain+" "+strings.Join(rule, " "))
+	}
+
+	return list, nil
+}
+
+// ClearChain flushes the chain in the specified table, creating it if it does not exist
+func (b *Backend) ClearChain(table, chain string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.chains(table)[chain] = [][]string{}
+
+	return nil
+}
+
+// ChainExists checks if the chain exists in the specified table
+func (b *Backend) ChainExists(table, chain string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.tables[table][chain]
+
+	return ok, nil
+}
+
+// ReplaceChain replaces the content of the chain with the given rules,
+// creating it if it does not exist
+func (b *Backend) ReplaceChain(table, chain string, rules [][]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	replaced := make([][]string, 0, len(rules))
+	for _, rule := range rules {
+		replaced = append(replaced, copyRule(rule))
+	}
+	b.chains(table)[chain] = replaced
+
+	return nil
+}
+
+// Rules returns a copy of the rules in specified table/chain, in order
+func (b *Backend) Rules(table, chain string) [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules := [][]string{}
+	for _, rule := range b.tables[table][chain] {
+		rules = append(rules, copyRule(rule))
+	}
+
+	return rules
+}
+
+// ReplaceSets creates the sets with their entries
+func (b *Backend) ReplaceSets(sets []compiler.Set) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, set := range sets {
+		entries := []string{}
+		for _, entry := range set.Entries {
+			entries = append(entries, entry.Value)
+		}
+		b.sets[set.Name] = entries
+	}
+
+	return nil
+}
+
+// RemoveSets removes the sets whose name starts with prefix, except the ones to keep
+func (b *Backend) RemoveSets(prefix string, keep []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for name := range b.sets {
+		if strings.HasPrefix(name, prefix) && !contains(keep, name) {
+			delete(b.sets, name)
+		}
+	}
+
+	return nil
+}
+
+// Sets returns a copy of the entries of each set
+func (b *Backend) Sets() map[string][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sets := map[string][]string{}
+	for name, entries := range b.sets {
+		sets[name] = append([]string{}, entries...)
+	}
+
+	return sets
+}
+
+func (b *Backend) chains(table string) map[string][][]string {
+	if _, ok := b.tables[table]; !ok {
+		b.tables[table] = map[string][][]string{}
+	}
+
+	return b.tables[table]
+}
+
+func (b *Backend) chain(table, chain string) ([][]string, error) {
+	rules, ok := b.tables[table][chain]
+	if !ok {
+		return nil, fmt.Errorf("chain %s does not exist in table %s", chain, table)
+	}
+
+	return rules, nil
+}
+
+func copyRule(rule []string) []string {
+	return append([]string{}, rule...)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,89 @@
+package fake
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type FakeTestSuite struct {
+	suite.Suite
+	backend *Backend
+}
+
+func TestFakeTestSuite(t *testing.T) {
+	suite.Run(t, new(FakeTestSuite))
+}
+
+func (f *FakeTestSuite) SetupTest() {
+	f.backend = New()
+}
+
+func (f *FakeTestSuite) Test_New() {
+	f.Equal([][]string{{"-j", "RETURN"}}, f.backend.Rules("filter", "DOCKER-USER"))
+}
+
+func (f *FakeTestSuite) Test_InsertAppendOrder() {
+	f.NoError(f.backend.Insert("filter", "DOCKER-USER", 1, "-j", "DROP"))
+	f.NoError(f.backend.Append("filter", "DOCKER-USER", "-p", "tcp", "-j", "RETURN"))
+	f.NoError(f.backend.Insert("filter", "DOCKER-USER", 2, "-i", "eth0", "-j", "RETURN"))
+
+	expected := [][]string{
+		{"-j", "DROP"},
+		{"-i", "eth0", "-j", "RETURN"},
+		{"-j", "RETURN"},
+		{"-p", "tcp", "-j", "RETURN"},
+	}
+	f.Equal(expected, f.backend.Rules("filter", "DOCKER-USER"))
+
+	f.EqualError(f.backend.Insert("filter", "DOCKER-USER", 6, "-j", "DROP"), "index of insertion too big: 6")
+}
+
+func (f *FakeTestSuite) Test_ExistsAndDelete() {
+	exists, err := f.backend.Exists("filter", "DOCKER-USER", "-j", "RETURN")
+	f.NoError(err)
+	f.True(exists)
+
+	f.NoError(f.backend.Delete("filter", "DOCKER-USER", "-j", "RETURN"))
+
+	exists, err = f.backend.Exists("filter", "DOCKER-USER", "-j", "RETURN")
+	f.NoError(err)
+	f.False(exists)
+
+	f.Error(f.backend.Delete("filter", "DOCKER-USER", "-j", "RETURN"))
+}
+
+func (f *FakeTestSuite) Test_MissingChain() {
+	_, err := f.backend.Exists("filter", "MISSING", "-j", "RETURN")
+	f.EqualError(err, "chain MISSING does not exist in table filter")
+
+	_, err = f.backend.List("nat", "DOCKER-USER")
+	f.EqualError(err, "chain DOCKER-USER does not exist in table nat")
+}
+
+func (f *FakeTestSuite) Test_ClearAndReplaceChain() {
+	f.NoError(f.backend.ReplaceChain("filter", "DOCKER-USER", [][]string{{"-j", "DROP"}}))
+	f.Equal([][]string{{"-j", "DROP"}}, f.backend.Rules("filter", "DOCKER-USER"))
+
+	list, err := f.backend.List("filter", "DOCKER-USER")
+	f.NoError(err)
+	f.Equal([]string{"-N DOCKER-USER", "-A DOCKER-USER -j DROP"}, list)
+
+	f.NoError(f.backend.ClearChain("filter", "DOCKER-USER"))
+	f.Equal([][]string{}, f.backend.Rules("filter", "DOCKER-USER"))
+}
+
+func (f *FakeTestSuite) Test_Sets() {
+	network, _ := config.ParseAddress("10.0.0.0/8")
+
+	f.NoError(f.backend.ReplaceSets([]compiler.Set{
+		{Name: "docker-firewall-v4-0", Entries: []config.Address{network}},
+		{Name: "docker-firewall-v4-1"},
+		{Name: "other"},
+	}))
+	f.NoError(f.backend.RemoveSets("docker-firewall-v4-", []string{"docker-firewall-v4-0"}))
+
+	f.Equal(map[string][]string{"docker-firewall-v4-0": {"10.0.0.0/8"}, "other": {}}, f.backend.Sets())
+}
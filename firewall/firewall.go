@@ -1,176 +1,458 @@
 package firewall
 
 import (
+	"fmt"
 	"log"
-	"strconv"
+	"strings"
 
+	"github.com/albertogviana/docker-firewall/compiler"
 	"github.com/albertogviana/docker-firewall/config"
 	"github.com/coreos/go-iptables/iptables"
 )
 
-// Firewall defines the firewall structure and its dependencies
+// Firewall defines the firewall structure and its dependencies. The policy
+// decides the traffic no rule matched, and is logged when logDefault is set.
+// The compiled rules are optimized when optimize is set, and match their
+// sources with ipsets when ipset is set.
 type Firewall struct {
-	iptables *iptables.IPTables
+	families   []family
+	policy     string
+	logDefault bool
+	optimize   bool
+	ipset      bool
 }
 
-// DockerUserChain is the iptables chain used to create the rules
+// family is a backend programming the rules of one IP protocol
+type family struct {
+	proto   iptables.Protocol
+	backend Backend
+}
+
+// DockerUserChain is the iptables chain docker provides for user rules, it
+// only holds the jump to FirewallChain
 const DockerUserChain = "DOCKER-USER"
 
+// FirewallChain is the iptables chain owned by docker-firewall holding the rules
+const FirewallChain = "DOCKER-FIREWALL"
+
+// ForwardChain is the builtin chain docker hooks DockerUserChain into
+const ForwardChain = "FORWARD"
+
 // FilterTable is used for packet filtering on iptables
 const FilterTable = "filter"
 
 // ReturnTarget purpose is to return from a user-defined chain before rule matching on that chain has completed.
 const ReturnTarget = "RETURN"
 
-var baseRules = [][]string{
-	{"-j", "DROP"},
-	{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+// jumpRule sends the DOCKER-USER traffic to FirewallChain
+var jumpRule = []string{"-j", FirewallChain}
+
+// dockerUserJump sends the forwarded traffic to DOCKER-USER, added by docker
+var dockerUserJump = []string{"-j", DockerUserChain}
+
+// HookingBackend is implemented by the backends hooking their DOCKER-USER
+// chain into forward themselves, instead of relying on docker
+type HookingBackend interface {
+	HooksDockerUser() bool
+}
+
+// IPTablesBackend is the name of the iptables backend
+const IPTablesBackend = "iptables"
+
+// NFTablesBackend is the name of the nftables backend
+const NFTablesBackend = "nftables"
+
+// New returns a Firewall instance using the backend with the given name,
+// defaulting to iptables when the name is empty
+func New(backend string) (*Firewall, error) {
+	switch backend {
+	case "", IPTablesBackend:
+		return NewFirewall()
+	case NFTablesBackend:
+		return NewNFTablesFirewall()
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q", backend)
+	}
 }
 
-// NewFirewall returns a Firewall instance
+// NewFirewall returns a Firewall instance using the iptables backend. IPv6 is
+// managed through ip6tables when it is available on the host, and docker
+// hooks its IPv6 DOCKER-USER chain into FORWARD.
 func NewFirewall() (*Firewall, error) {
-	firewall := &Firewall{}
+	ipt, err := NewIPTables(iptables.ProtocolIPv4)
+	if err != nil {
+		return nil, err
+	}
+
+	ip6t, err := NewIPTables(iptables.ProtocolIPv6)
+	if err != nil {
+		log.Printf("ip6tables is not available, IPv6 traffic will not be filtered: %v", err)
+		return NewFirewallWithBackend(ipt), nil
+	}
+
+	hooked, err := hooked(ip6t)
+	if err != nil {
+		log.Printf("can not read the IPv6 %s chain, IPv6 traffic will not be filtered: %v", ForwardChain, err)
+		return NewFirewallWithBackend(ipt), nil
+	}
 
-	ipt, err := iptables.New()
+	if !hooked {
+		log.Printf("%s is not hooked into the IPv6 %s chain, enable ip6tables in the docker daemon configuration to filter IPv6 traffic", DockerUserChain, ForwardChain)
+		return NewFirewallWithBackend(ipt), nil
+	}
+
+	return NewFirewallWithBackends(ipt, ip6t), nil
+}
+
+// NewNFTablesFirewall returns a Firewall instance using the nftables backend
+// for both IPv4 and IPv6
+func NewNFTablesFirewall() (*Firewall, error) {
+	nft, err := NewNFTables("ip")
 	if err != nil {
 		return nil, err
 	}
 
-	firewall.iptables = ipt
+	nft6, err := NewNFTables("ip6")
+	if err != nil {
+		return nil, err
+	}
 
-	return firewall, nil
+	return NewFirewallWithBackends(nft, nft6), nil
 }
 
-// Apply parse the configuration and applying it in the system
-func (f *Firewall) Apply(rules []config.Rule) error {
-	iptablesRules := baseRules
+// NewFirewallWithBackend returns a Firewall instance managing only IPv4 with the given backend
+func NewFirewallWithBackend(backend Backend) *Firewall {
+	return NewFirewallWithBackends(backend, nil)
+}
 
-	for _, rule := range rules {
-		r := generateRules(rule)
-		iptablesRules = append(iptablesRules, r...)
+// NewFirewallWithBackends returns a Firewall instance managing IPv4 and IPv6
+// with the given backends, a nil ipv6 backend leaves IPv6 unmanaged
+func NewFirewallWithBackends(ipv4, ipv6 Backend) *Firewall {
+	firewall := &Firewall{families: []family{{proto: iptables.ProtocolIPv4, backend: ipv4}}}
+
+	if ipv6 != nil {
+		firewall.families = append(firewall.families, family{proto: iptables.ProtocolIPv6, backend: ipv6})
 	}
 
-	f.ClearRule()
-	for _, iptRule := range iptablesRules {
-		err := f.iptables.Insert(FilterTable, DockerUserChain, 1, iptRule...)
+	return firewall
+}
+
+// SetDefaultPolicy sets what happens to the traffic no rule matched, drop
+// when the policy is empty, and whether it is logged
+func (f *Firewall) SetDefaultPolicy(policy string, log bool) {
+	f.policy = policy
+	f.logDefault = log
+}
+
+// SetOptimize sets whether the rules are optimized before being applied
+func (f *Firewall) SetOptimize(optimize bool) {
+	f.optimize = optimize
+}
+
+// SetIPSet sets whether the rules match their sources with ipsets, which
+// requires a SetBackend
+func (f *Firewall) SetIPSet(ipset bool) {
+	f.ipset = ipset
+}
+
+// Apply parse the configuration and applying it in the system. The whole
+// DOCKER-FIREWALL chain is replaced at once, so it holds either the old or the
+// new rules, and a jump to it is added to DOCKER-USER when missing. Other
+// rules in DOCKER-USER are left untouched, and since the jump comes first
+// they only see the traffic DOCKER-FIREWALL returned. The IPv6 DOCKER-USER chain
+// is skipped when docker does not hook it into FORWARD, an unhooked IPv4 one
+// being an error. The ipsets are filled before the
+// rules matching them are applied, and the ones no longer matched removed
+// after, even when ipsets are disabled.
+func (f *Firewall) Apply(rules []config.Rule) error {
+	for _, family := range f.families {
+		hooked, err := hooked(family.backend)
 		if err != nil {
-			log.Fatalf("Error Insert rule: %v", iptRule)
 			return err
 		}
+
+		if !hooked && family.proto == iptables.ProtocolIPv4 {
+			return fmt.Errorf("%s is not hooked into the IPv4 %s chain, the rules would not filter any traffic", DockerUserChain, ForwardChain)
+		}
+
+		if !hooked {
+			log.Printf("%s is not hooked into the %s %s chain, %s traffic will not be filtered", DockerUserChain, protocolName(family.proto), ForwardChain, protocolName(family.proto))
+			continue
+		}
+
+		chain, sets, _ := f.compile(rules, family.proto)
+		if f.ipset {
+			err := f.replaceSets(family, sets)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = replaceChain(family.backend, chain)
+		if err != nil {
+			return fmt.Errorf("error applying %s rules: %v", protocolName(family.proto), err)
+		}
+
+		if backend, ok := family.backend.(SetBackend); ok {
+			names := []string{}
+			for _, set := range sets {
+				names = append(names, set.Name)
+			}
+
+			err = backend.RemoveSets(compiler.SetPrefix(familyOf(family.proto)), names)
+			if err != nil {
+				return fmt.Errorf("error removing the unused %s ipsets: %v", protocolName(family.proto), err)
+			}
+		}
+
+		exists, err := family.backend.Exists(FilterTable, DockerUserChain, jumpRule...)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			err = family.backend.Insert(FilterTable, DockerUserChain, 1, jumpRule...)
+			if err != nil {
+				return fmt.Errorf("error adding the %s jump to %s: %v", protocolName(family.proto), FirewallChain, err)
+			}
+		}
 	}
 
 	return nil
 }
 
-// Verify checks if the rules in the configuration files where applied.
-func (f *Firewall) Verify(rules []config.Rule) (bool, error) {
-	iptablesRules := baseRules
+// hooked reports if the DOCKER-USER chain of a backend exists and FORWARD
+// jumps to it. Docker only creates them for IPv6 when ip6tables is enabled
+// in its daemon configuration, and the rules of the chain see no traffic
+// otherwise.
+func hooked(backend Backend) (bool, error) {
+	if hooking, ok := backend.(HookingBackend); ok && hooking.HooksDockerUser() {
+		return true, nil
+	}
+
+	exists, err := backend.ChainExists(FilterTable, DockerUserChain)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	return backend.Exists(FilterTable, ForwardChain, dockerUserJump...)
+}
+
+// replaceChain replaces the DOCKER-FIREWALL chain with the compiled rules,
+// programmed as their iptables rule specs unless the backend is a
+// CompiledBackend
+func replaceChain(backend Backend, chain []compiler.Rule) error {
+	if compiled, ok := backend.(CompiledBackend); ok {
+		return compiled.ReplaceCompiled(FilterTable, FirewallChain, chain)
+	}
+
+	specs := [][]string{}
+	for _, rule := range chain {
+		specs = append(specs, compiler.IPTables(rule)...)
+	}
+
+	return backend.ReplaceChain(FilterTable, FirewallChain, specs)
+}
 
-	for _, rule := range rules {
-		r := generateRules(rule)
-		iptablesRules = append(iptablesRules, r...)
+func (f *Firewall) replaceSets(family family, sets []compiler.Set) error {
+	backend, ok := family.backend.(SetBackend)
+	if !ok {
+		return fmt.Errorf("the %s backend does not support ipsets", protocolName(family.proto))
 	}
 
+	err := backend.ReplaceSets(sets)
+	if err != nil {
+		return fmt.Errorf("error applying %s ipsets: %v", protocolName(family.proto), err)
+	}
+
+	return nil
+}
+
+// Verify checks if the rules in the configuration files where applied, and
+// if DOCKER-USER still jumps to them. The IPv6 rules Apply skips are not
+// checked, and the rules are not verified while IPv4 DOCKER-USER is not hooked.
+func (f *Firewall) Verify(rules []config.Rule) (bool, error) {
 	result := true
-	for _, rule := range iptablesRules {
-		exists, err := f.iptables.Exists(FilterTable, DockerUserChain, rule...)
+	for _, family := range f.families {
+		hooked, err := hooked(family.backend)
+		if err != nil {
+			return false, err
+		}
+
+		if !hooked {
+			if family.proto == iptables.ProtocolIPv4 {
+				result = false
+			}
+			continue
+		}
+
+		exists, err := family.backend.Exists(FilterTable, DockerUserChain, jumpRule...)
 		if err != nil {
 			return false, err
 		}
 
 		if !exists {
 			result = false
+			continue
+		}
+
+		for _, rule := range f.chainRules(rules, family.proto) {
+			exists, err := family.backend.Exists(FilterTable, FirewallChain, rule...)
+			if err != nil {
+				return false, err
+			}
+
+			if !exists {
+				result = false
+			}
 		}
 	}
 
 	return result, nil
 }
 
-// ClearRule cleans the DOCKER-USER chain
+// ClearRule cleans the DOCKER-FIREWALL chain and removes the jump to it from
+// DOCKER-USER, without touching other rules. The ipsets are then removed,
+// even when ipsets are disabled.
 func (f *Firewall) ClearRule() error {
-	err := f.iptables.ClearChain(FilterTable, DockerUserChain)
-	if err != nil {
-		return err
-	}
+	for _, family := range f.families {
+		exists, err := family.backend.ChainExists(FilterTable, DockerUserChain)
+		if err != nil {
+			return err
+		}
 
-	err = f.iptables.Insert(FilterTable, DockerUserChain, 1, "-j", "RETURN")
-	if err != nil {
-		return err
+		if exists {
+			exists, err = family.backend.Exists(FilterTable, DockerUserChain, jumpRule...)
+			if err != nil {
+				return err
+			}
+		}
+
+		if exists {
+			err = family.backend.Delete(FilterTable, DockerUserChain, jumpRule...)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = family.backend.ClearChain(FilterTable, FirewallChain)
+		if err != nil {
+			return err
+		}
+
+		if backend, ok := family.backend.(SetBackend); ok {
+			err = backend.RemoveSets(compiler.SetPrefix(familyOf(family.proto)), nil)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func generateRules(rule config.Rule) [][]string {
-	rules := [][]string{}
+// Render returns the DOCKER-FIREWALL rules Apply sets for each managed IP
+// protocol, as iptables arguments
+func (f *Firewall) Render(rules []config.Rule) map[string][]string {
+	rendered := map[string][]string{}
 
-	baseRule := []string{}
-	if rule.Protocol != "" {
-		baseRule = append(baseRule, "-p", rule.Protocol, "-m", rule.Protocol)
+	for _, family := range f.families {
+		lines := []string{}
+		for _, rule := range f.chainRules(rules, family.proto) {
+			lines = append(lines, strings.Join(rule, " "))
+		}
+		rendered[protocolName(family.proto)] = lines
 	}
 
-	if rule.Port > 0 {
-		baseRule = append(baseRule, "--dport", strconv.Itoa(rule.Port))
+	return rendered
+}
+
+// chainRules renders the DOCKER-FIREWALL chain, in order, for the given rules and IP protocol
+func (f *Firewall) chainRules(rules []config.Rule, proto iptables.Protocol) [][]string {
+	iptablesRules := [][]string{}
+
+	for _, entry := range f.chainEntries(rules, proto) {
+		iptablesRules = append(iptablesRules, entry.spec)
 	}
 
-	if len(rule.Interface) > 0 {
-		for _, i := range rule.Interface {
-			interfaceRule := []string{}
-			interfaceRule = append(interfaceRule, "-i", i)
-			interfaceRule = append(interfaceRule, baseRule...)
-			rules = append(rules, interfaceRule)
+	return iptablesRules
+}
+
+// chainEntry is a DOCKER-FIREWALL rule and the index of the configuration
+// rule it was rendered from
+type chainEntry struct {
+	rule int
+	spec []string
+}
+
+func (f *Firewall) chainEntries(rules []config.Rule, proto iptables.Protocol) []chainEntry {
+	entries := []chainEntry{}
+
+	chain, _, _ := f.compile(rules, proto)
+	for _, rule := range chain {
+		for _, spec := range compiler.IPTables(rule) {
+			entries = append(entries, chainEntry{rule: rule.Origin, spec: spec})
 		}
 	}
 
-	if rule.Protocol == "" {
-		if len(rules) == 0 {
-			tmpRules := [][]string{}
-			tcp := []string{}
-			tcp = append(tcp, "-p", "tcp", "-m", "tcp")
-			tcp = append(tcp, baseRule...)
-			tmpRules = append(tmpRules, tcp)
+	return entries
+}
 
-			udp := []string{}
-			udp = append(udp, "-p", "udp", "-m", "udp")
-			udp = append(udp, baseRule...)
-			tmpRules = append(tmpRules, udp)
-			rules = tmpRules
-		}
+// compile compiles the DOCKER-FIREWALL chain of an IP protocol, optimized
+// when enabled, with the sets it matches and the optimization report
+func (f *Firewall) compile(rules []config.Rule, proto iptables.Protocol) ([]compiler.Rule, []compiler.Set, compiler.Report) {
+	chain := compiler.Chain(rules, familyOf(proto), compiler.Policy{Default: f.policy, Log: f.logDefault})
 
+	report := compiler.Report{Before: len(chain), After: len(chain)}
+	if f.optimize {
+		chain, report = compiler.Optimize(chain)
 	}
 
-	if len(rule.Allow) > 0 {
-		tmpRules := [][]string{}
-		for _, ip := range rule.Allow {
-			if len(rules) > 0 {
-				for _, v := range rules {
-					ipRule := []string{}
-					ipRule = append(ipRule, "-s", ip)
-					ipRule = append(ipRule, v...)
-					tmpRules = append(tmpRules, ipRule)
-				}
-			} else {
-				if rule.Protocol != "" {
-					ipRule := []string{}
-					ipRule = append(ipRule, "-s", ip)
-					ipRule = append(ipRule, baseRule...)
-					tmpRules = append(tmpRules, ipRule)
-				}
-			}
-		}
-		rules = tmpRules
+	if !f.ipset {
+		return chain, nil, report
 	}
 
-	if len(rules) > 0 {
-		tmpRules := rules
-		rules = [][]string{}
-		for _, v := range tmpRules {
-			targetRule := []string{}
-			targetRule = append(targetRule, v...)
-			targetRule = append(targetRule, "-j", ReturnTarget)
-			rules = append(rules, targetRule)
-		}
+	chain, sets := compiler.UseSets(chain, familyOf(proto))
+
+	return chain, sets, report
+}
+
+// Optimization returns how much the optimization reduces the compiled rules
+// of each managed IP protocol, nothing is reduced when it is disabled
+func (f *Firewall) Optimization(rules []config.Rule) map[string]compiler.Report {
+	reports := map[string]compiler.Report{}
+
+	for _, family := range f.families {
+		_, _, reports[protocolName(family.proto)] = f.compile(rules, family.proto)
+	}
+
+	return reports
+}
+
+// generateRules renders the iptables rules of the given IP protocol for a rule
+func generateRules(rule config.Rule, proto iptables.Protocol) [][]string {
+	rules := [][]string{}
+
+	for _, compiled := range compiler.Compile(rule, 0, familyOf(proto)) {
+		rules = append(rules, compiler.IPTables(compiled)...)
 	}
 
 	return rules
 }
+
+// familyOf returns the compiler family of an IP protocol
+func familyOf(proto iptables.Protocol) compiler.Family {
+	if proto == iptables.ProtocolIPv6 {
+		return compiler.IPv6
+	}
+
+	return compiler.IPv4
+}
+
+func protocolName(proto iptables.Protocol) string {
+	if proto == iptables.ProtocolIPv6 {
+		return "IPv6"
+	}
+
+	return "IPv4"
+}
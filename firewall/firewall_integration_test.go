@@ -0,0 +1,103 @@
+//go:build integration
+// +build integration
+
+package firewall
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/suite"
+)
+
+// IntegrationTestSuite runs against the kernel, root permissions are required
+type IntegrationTestSuite struct {
+	suite.Suite
+}
+
+func TestIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(IntegrationTestSuite))
+}
+
+func (f *IntegrationTestSuite) Test_NewFirewall() {
+	firewall, err := NewFirewall()
+	f.NoError(err)
+	f.IsType(&Firewall{}, firewall)
+	f.IsType(&IPTables{}, firewall.families[0].backend)
+}
+
+func (f *IntegrationTestSuite) Test_Rules() {
+	configuration := &config.Configuration{}
+	rule1 := config.Rule{
+		Interface: []string{"eth0"},
+		Protocol:  "tcp",
+		Port:      8080,
+		Allow:     []string{"10.1.1.1"},
+	}
+
+	rule2 := config.Rule{
+		Port:  8080,
+		Allow: []string{"10.1.1.1", "10.2.1.2", "172.18.9.5", "192.168.1.15"},
+	}
+
+	rule3 := config.Rule{
+		Port: 8080,
+	}
+
+	rule4 := config.Rule{
+		Interface: []string{"docker_gwbridge"},
+	}
+
+	configuration.Config.Rules = append(configuration.Config.Rules, rule1, rule2, rule3, rule4)
+
+	firewall, err := NewFirewall()
+	f.NoError(err)
+
+	err = firewall.Apply(configuration.Config.Rules)
+	f.NoError(err)
+
+	expectedRules := [][]string{
+		{"-j", "DROP"},
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.1.1.1", "-i", "eth0", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "10.1.1.1", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "10.1.1.1", "-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "10.2.1.2", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "10.2.1.2", "-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "172.18.9.5", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "172.18.9.5", "-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "192.168.1.15", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-s", "192.168.1.15", "-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-i", "docker_gwbridge", "-j", "RETURN"},
+	}
+
+	ipt, err := iptables.New()
+	f.NoError(err)
+
+	for _, rule := range expectedRules {
+		exists, err := ipt.Exists(FilterTable, FirewallChain, rule...)
+		f.NoError(err)
+
+		var msg interface{}
+		msg = fmt.Sprintf("Rule %s not found", rule)
+		f.True(exists, msg)
+	}
+
+	verifyRules, err := firewall.Verify(configuration.Config.Rules)
+	f.NoError(err)
+	f.True(verifyRules)
+
+	firewall.ClearRule()
+	for _, rule := range expectedRules {
+		exists, err := ipt.Exists(FilterTable, FirewallChain, rule...)
+		f.NoError(err)
+
+		var msg interface{}
+		msg = fmt.Sprintf("Rule %s not found", rule)
+		f.False(exists, msg)
+	}
+}
@@ -2,26 +2,28 @@ package firewall
 
 import (
 	"fmt"
+	"net"
+	"reflect"
 	"testing"
 
+	"github.com/albertogviana/docker-firewall/compiler"
 	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/firewall/fake"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/stretchr/testify/suite"
 )
 
 type FirewallTestSuite struct {
 	suite.Suite
+	backend *fake.Backend
 }
 
 func TestFirewallTestSuite(t *testing.T) {
 	suite.Run(t, new(FirewallTestSuite))
 }
 
-func (f *FirewallTestSuite) Test_NewFirewall() {
-	firewall, err := NewFirewall()
-	f.NoError(err)
-	f.IsType(&Firewall{}, firewall)
-	f.IsType(&iptables.IPTables{}, firewall.iptables)
+func (f *FirewallTestSuite) SetupTest() {
+	f.backend = fake.New()
 }
 
 func (f *FirewallTestSuite) Test_Rules() {
@@ -48,10 +50,9 @@ func (f *FirewallTestSuite) Test_Rules() {
 
 	configuration.Config.Rules = append(configuration.Config.Rules, rule1, rule2, rule3, rule4)
 
-	firewall, err := NewFirewall()
-	f.NoError(err)
+	firewall := NewFirewallWithBackend(f.backend)
 
-	err = firewall.Apply(configuration.Config.Rules)
+	err := firewall.Apply(configuration.Config.Rules)
 	f.NoError(err)
 
 	expectedRules := [][]string{
@@ -71,11 +72,8 @@ func (f *FirewallTestSuite) Test_Rules() {
 		{"-i", "docker_gwbridge", "-j", "RETURN"},
 	}
 
-	ipt, err := iptables.New()
-	f.NoError(err)
-
 	for _, rule := range expectedRules {
-		exists, err := ipt.Exists(FilterTable, DockerUserChain, rule...)
+		exists, err := f.backend.Exists(FilterTable, FirewallChain, rule...)
 		f.NoError(err)
 
 		var msg interface{}
@@ -89,7 +87,7 @@ func (f *FirewallTestSuite) Test_Rules() {
 
 	firewall.ClearRule()
 	for _, rule := range expectedRules {
-		exists, err := ipt.Exists(FilterTable, DockerUserChain, rule...)
+		exists, err := f.backend.Exists(FilterTable, FirewallChain, rule...)
 		f.NoError(err)
 
 		var msg interface{}
@@ -98,6 +96,433 @@ func (f *FirewallTestSuite) Test_Rules() {
 	}
 }
 
+func (f *FirewallTestSuite) Test_ApplyOrder() {
+	rules := []config.Rule{
+		{
+			Protocol: "tcp",
+			Port:     3000,
+			Allow:    []string{"10.1.1.1"},
+		},
+		{
+			Interface: []string{"docker_gwbridge"},
+		},
+	}
+
+	firewall := NewFirewallWithBackend(f.backend)
+
+	err := firewall.Apply(rules)
+	f.NoError(err)
+
+	expectedRules := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.1.1.1", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+		{"-i", "docker_gwbridge", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}
+	f.Equal(expectedRules, f.backend.Rules(FilterTable, FirewallChain))
+
+	err = firewall.ClearRule()
+	f.NoError(err)
+	f.Equal([][]string{}, f.backend.Rules(FilterTable, FirewallChain))
+	f.Equal([][]string{{"-j", "RETURN"}}, f.backend.Rules(FilterTable, DockerUserChain))
+}
+
+func (f *FirewallTestSuite) Test_DefaultPolicy() {
+	ipv6 := fake.New()
+	firewall := NewFirewallWithBackends(f.backend, ipv6)
+	rules := []config.Rule{{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.0/24"}, Action: "drop"}}
+
+	firewall.SetDefaultPolicy("reject", true)
+	f.Require().NoError(firewall.Apply(rules))
+
+	f.Equal([][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "203.0.113.0/24", "-p", "tcp", "-m", "tcp", "--dport", "443", "-j", "DROP"},
+		{"-m", "limit", "--limit", "5/min", "-j", "LOG", "--log-prefix", "docker-firewall: "},
+		{"-j", "REJECT", "--reject-with", "icmp-port-unreachable"},
+	}, f.backend.Rules(FilterTable, FirewallChain))
+	f.Equal([][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-m", "limit", "--limit", "5/min", "-j", "LOG", "--log-prefix", "docker-firewall: "},
+		{"-j", "REJECT", "--reject-with", "icmp6-port-unreachable"},
+	}, ipv6.Rules(FilterTable, FirewallChain))
+
+	verified, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verified)
+
+	firewall.SetDefaultPolicy("return", false)
+	verified, err = firewall.Verify(rules)
+	f.NoError(err)
+	f.False(verified)
+
+	f.Require().NoError(firewall.Apply(rules))
+	f.Equal([][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "203.0.113.0/24", "-p", "tcp", "-m", "tcp", "--dport", "443", "-j", "DROP"},
+		{"-j", "RETURN"},
+	}, f.backend.Rules(FilterTable, FirewallChain))
+}
+
+func (f *FirewallTestSuite) Test_Optimize() {
+	firewall := NewFirewallWithBackend(f.backend)
+	rules := []config.Rule{
+		{Protocol: "tcp", Port: 80, Allow: []string{"10.0.0.0/8"}},
+		{Protocol: "tcp", Port: 443, Allow: []string{"10.0.0.0/8"}},
+		{Protocol: "tcp", Port: 80, Allow: []string{"10.1.0.0/16"}},
+		{Protocol: "tcp", Port: 80, Allow: []string{"10.0.0.0/8"}},
+	}
+
+	firewall.SetOptimize(true)
+	f.Require().NoError(firewall.Apply(rules))
+
+	f.Equal([][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.0.0.0/8", "-p", "tcp", "-m", "multiport", "--dports", "80,443", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}, f.backend.Rules(FilterTable, FirewallChain))
+	f.Equal("6 rules optimized to 3: 1 duplicates, 1 shadowed, 1 merged", firewall.Optimization(rules)["IPv4"].String())
+
+	verified, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verified)
+}
+
+func (f *FirewallTestSuite) Test_IPSet() {
+	ipv6 := fake.New()
+	firewall := NewFirewallWithBackends(f.backend, ipv6)
+	firewall.SetIPSet(true)
+
+	rules := []config.Rule{
+		{Protocol: "tcp", Port: 9100, Allow: []string{"10.0.0.0/8", "192.168.1.15", "2001:db8::/32"}},
+		{Protocol: "tcp", Port: 22, Allow: []string{"10.1.1.1"}},
+	}
+	f.Require().NoError(firewall.Apply(rules))
+
+	f.Equal([][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-m", "set", "--match-set", "docker-firewall-v4-9a7ae58c", "src", "-p", "tcp", "-m", "tcp", "--dport", "9100", "-j", "RETURN"},
+		{"-m", "set", "--match-set", "docker-firewall-v4-0de54994", "src", "-p", "tcp", "-m", "tcp", "--dport", "22", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}, f.backend.Rules(FilterTable, FirewallChain))
+	f.Equal(map[string][]string{
+		"docker-firewall-v4-9a7ae58c": {"10.0.0.0/8", "192.168.1.15"},
+		"docker-firewall-v4-0de54994": {"10.1.1.1"},
+	}, f.backend.Sets())
+	f.Equal(map[string][]string{"docker-firewall-v6-90c2cbc2": {"2001:db8::/32"}}, ipv6.Sets())
+
+	rules = rules[:1]
+	rules[0].Allow = []string{"10.0.0.0/8", "172.16.0.0/12"}
+	f.Require().NoError(firewall.Apply(rules))
+	f.Equal([][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-m", "set", "--match-set", "docker-firewall-v4-7e2f08f6", "src", "-p", "tcp", "-m", "tcp", "--dport", "9100", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}, f.backend.Rules(FilterTable, FirewallChain))
+	f.Equal(map[string][]string{"docker-firewall-v4-7e2f08f6": {"10.0.0.0/8", "172.16.0.0/12"}}, f.backend.Sets())
+	f.Empty(ipv6.Sets())
+
+	verified, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verified)
+
+	firewall.SetIPSet(false)
+	f.Require().NoError(firewall.Apply(rules))
+	f.Empty(f.backend.Sets())
+
+	firewall.SetIPSet(true)
+	f.Require().NoError(firewall.Apply(rules))
+	firewall.SetIPSet(false)
+	f.Require().NoError(firewall.ClearRule())
+	f.Empty(f.backend.Sets())
+
+	unsupported := NewFirewallWithBackend(struct{ Backend }{fake.New()})
+	unsupported.SetIPSet(true)
+	f.EqualError(unsupported.Apply(rules), "the IPv4 backend does not support ipsets")
+}
+
+func (f *FirewallTestSuite) Test_ForeignRulesPreserved() {
+	rules := []config.Rule{
+		{
+			Port: 8080,
+		},
+	}
+
+	vpnRule := []string{"-i", "tun0", "-j", "ACCEPT"}
+	err := f.backend.Insert(FilterTable, DockerUserChain, 1, vpnRule...)
+	f.NoError(err)
+
+	firewall := NewFirewallWithBackend(f.backend)
+
+	err = firewall.Apply(rules)
+	f.NoError(err)
+
+	err = firewall.Apply(rules)
+	f.NoError(err)
+
+	expected := [][]string{
+		{"-j", "DOCKER-FIREWALL"},
+		{"-i", "tun0", "-j", "ACCEPT"},
+		{"-j", "RETURN"},
+	}
+	f.Equal(expected, f.backend.Rules(FilterTable, DockerUserChain))
+
+	verify, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verify)
+
+	err = f.backend.Delete(FilterTable, DockerUserChain, "-j", "DOCKER-FIREWALL")
+	f.NoError(err)
+
+	verify, err = firewall.Verify(rules)
+	f.NoError(err)
+	f.False(verify)
+
+	err = firewall.ClearRule()
+	f.NoError(err)
+	f.Equal([][]string{{"-i", "tun0", "-j", "ACCEPT"}, {"-j", "RETURN"}}, f.backend.Rules(FilterTable, DockerUserChain))
+}
+
+// Test_ForeignRulesOrder walks DOCKER-USER in order: the rules of other tools
+// only see the traffic DOCKER-FIREWALL returned, the rest being decided by it
+func (f *FirewallTestSuite) Test_ForeignRulesOrder() {
+	rules := []config.Rule{{Protocol: "tcp", Port: 8080}}
+	vpnRule := []string{"-i", "tun0", "-j", "ACCEPT"}
+	f.Require().NoError(f.backend.Insert(FilterTable, DockerUserChain, 1, vpnRule...))
+
+	firewall := NewFirewallWithBackend(f.backend)
+	f.Require().NoError(firewall.Apply(rules))
+	chain, _, _ := firewall.compile(rules, iptables.ProtocolIPv4)
+
+	decide := func(packet compiler.Packet) string {
+		for _, rule := range f.backend.Rules(FilterTable, DockerUserChain) {
+			switch {
+			case reflect.DeepEqual(rule, jumpRule):
+				decision, _ := compiler.Evaluate(chain, packet)
+				if decision.Rule.Action.Verdict != config.ActionReturn {
+					return FirewallChain + " " + decision.Rule.Action.Verdict
+				}
+			case reflect.DeepEqual(rule, vpnRule) && packet.Interface == "tun0":
+				return "tun0 accept"
+			}
+		}
+
+		return "forwarded"
+	}
+
+	source := net.ParseIP("10.8.0.2")
+	f.Equal("tun0 accept", decide(compiler.Packet{Source: source, Interface: "tun0", Protocol: "tcp", Port: 8080}))
+	f.Equal(FirewallChain+" drop", decide(compiler.Packet{Source: source, Interface: "tun0", Protocol: "tcp", Port: 22}))
+	f.Equal("forwarded", decide(compiler.Packet{Source: source, Interface: "eth0", Protocol: "tcp", Port: 8080}))
+}
+
+func (f *FirewallTestSuite) Test_VerifyDetectsMissingRule() {
+	rules := []config.Rule{
+		{
+			Port: 8080,
+		},
+	}
+
+	firewall := NewFirewallWithBackend(f.backend)
+
+	verify, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.False(verify)
+
+	err = firewall.Apply(rules)
+	f.NoError(err)
+
+	verify, err = firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verify)
+
+	err = f.backend.Delete(FilterTable, FirewallChain, "-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN")
+	f.NoError(err)
+
+	verify, err = firewall.Verify(rules)
+	f.NoError(err)
+	f.False(verify)
+}
+
+func (f *FirewallTestSuite) Test_DualStack() {
+	rules := []config.Rule{
+		{
+			Protocol: "tcp",
+			Port:     3000,
+			Allow:    []string{"10.1.1.1", "fd00::1", "2001:db8::/32"},
+		},
+		{
+			Protocol: "tcp",
+			Port:     9100,
+			Allow:    []string{"10.0.1.15"},
+		},
+		{
+			Port: 8080,
+		},
+	}
+
+	backend6 := fake.New()
+	firewall := NewFirewallWithBackends(f.backend, backend6)
+
+	err := firewall.Apply(rules)
+	f.NoError(err)
+
+	expectedRules := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.1.1.1", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+		{"-s", "10.0.1.15", "-p", "tcp", "-m", "tcp", "--dport", "9100", "-j", "RETURN"},
+		{"-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}
+	f.Equal(expectedRules, f.backend.Rules(FilterTable, FirewallChain))
+
+	expectedRules6 := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "fd00::1", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+		{"-s", "2001:db8::/32", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+		{"-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+		{"-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}
+	f.Equal(expectedRules6, backend6.Rules(FilterTable, FirewallChain))
+
+	verify, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verify)
+
+	err = backend6.ClearChain(FilterTable, FirewallChain)
+	f.NoError(err)
+
+	verify, err = firewall.Verify(rules)
+	f.NoError(err)
+	f.False(verify)
+}
+
+func (f *FirewallTestSuite) Test_DockerUserNotHooked() {
+	backend6 := fake.New()
+	f.Require().NoError(backend6.Delete(FilterTable, ForwardChain, "-j", DockerUserChain))
+	firewall := NewFirewallWithBackends(f.backend, backend6)
+	rules := []config.Rule{{Protocol: "tcp", Port: 3000}}
+
+	f.Require().NoError(firewall.Apply(rules))
+	f.Equal([][]string{{"-j", FirewallChain}, {"-j", "RETURN"}}, f.backend.Rules(FilterTable, DockerUserChain))
+	f.Equal([][]string{{"-j", "RETURN"}}, backend6.Rules(FilterTable, DockerUserChain))
+	f.Empty(backend6.Rules(FilterTable, FirewallChain))
+
+	verified, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.True(verified)
+
+	plans, err := firewall.Plan(rules)
+	f.NoError(err)
+	f.Len(plans, 1)
+	f.Equal("IPv4", plans[0].Family)
+
+	snapshot, err := firewall.Snapshot()
+	f.Require().NoError(err)
+	f.NoError(firewall.Restore(snapshot))
+	f.NoError(firewall.ClearRule())
+}
+
+func (f *FirewallTestSuite) Test_DockerUserNotHooked_IPv4() {
+	rules := []config.Rule{{Protocol: "tcp", Port: 3000}}
+	firewall := NewFirewallWithBackend(f.backend)
+	f.Require().NoError(firewall.Apply(rules))
+	f.Require().NoError(f.backend.Delete(FilterTable, ForwardChain, "-j", DockerUserChain))
+
+	f.EqualError(firewall.Apply(rules), "DOCKER-USER is not hooked into the IPv4 FORWARD chain, the rules would not filter any traffic")
+
+	verified, err := firewall.Verify(rules)
+	f.NoError(err)
+	f.False(verified)
+}
+
+func (f *FirewallTestSuite) Test_Render() {
+	firewall := NewFirewallWithBackends(fake.New(), fake.New())
+	rules := []config.Rule{{Protocol: "tcp", Port: 8080, Allow: []string{"fd00::1"}}}
+
+	expected := map[string][]string{
+		"IPv4": {
+			"-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN",
+			"-j DROP",
+		},
+		"IPv6": {
+			"-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN",
+			"-s fd00::1 -p tcp -m tcp --dport 8080 -j RETURN",
+			"-j DROP",
+		},
+	}
+	f.Equal(expected, firewall.Render(rules))
+}
+
+func (f *FirewallTestSuite) Test_GenerateRules_MultiportChunks() {
+	rule := config.Rule{
+		Protocol: "tcp",
+		Port:     22,
+	}
+	for port := 1000; port < 1016; port++ {
+		rule.Ports = append(rule.Ports, config.PortRange{From: port, To: port})
+	}
+	rule.Ports = append(rule.Ports, config.PortRange{From: 2000, To: 2100})
+
+	expected := [][]string{
+		{"-p", "tcp", "-m", "multiport", "--dports", "22,1000,1001,1002,1003,1004,1005,1006,1007,1008,1009,1010,1011,1012,1013", "-j", "RETURN"},
+		{"-p", "tcp", "-m", "multiport", "--dports", "1014,1015,2000:2100", "-j", "RETURN"},
+	}
+
+	f.Equal(expected, generateRules(rule, iptables.ProtocolIPv4))
+}
+
+func (f *FirewallTestSuite) Test_GenerateRules_Actions() {
+	var tests = []struct {
+		rule config.Rule
+		ipv4 [][]string
+		ipv6 [][]string
+	}{
+		{
+			config.Rule{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.0/24"}, Action: "drop"},
+			[][]string{{"-s", "203.0.113.0/24", "-p", "tcp", "-m", "tcp", "--dport", "443", "-j", "DROP"}},
+			[][]string{},
+		},
+		{
+			config.Rule{Protocol: "tcp", Port: 443, Action: "reject", RejectWith: "tcp-reset"},
+			[][]string{{"-p", "tcp", "-m", "tcp", "--dport", "443", "-j", "REJECT", "--reject-with", "tcp-reset"}},
+			[][]string{{"-p", "tcp", "-m", "tcp", "--dport", "443", "-j", "REJECT", "--reject-with", "tcp-reset"}},
+		},
+		{
+			config.Rule{Protocol: "udp", Port: 53, Action: "reject"},
+			[][]string{{"-p", "udp", "-m", "udp", "--dport", "53", "-j", "REJECT", "--reject-with", "icmp-port-unreachable"}},
+			[][]string{{"-p", "udp", "-m", "udp", "--dport", "53", "-j", "REJECT", "--reject-with", "icmp6-port-unreachable"}},
+		},
+		{
+			config.Rule{Protocol: "udp", Port: 53, Action: "reject", RejectWith: "icmp6-adm-prohibited"},
+			[][]string{{"-p", "udp", "-m", "udp", "--dport", "53", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"}},
+			[][]string{{"-p", "udp", "-m", "udp", "--dport", "53", "-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"}},
+		},
+		{
+			config.Rule{Protocol: "tcp", Port: 22, Action: "log", LogPrefix: "ssh: ", LogLevel: "info"},
+			[][]string{{"-p", "tcp", "-m", "tcp", "--dport", "22", "-j", "LOG", "--log-prefix", "ssh: ", "--log-level", "6"}},
+			[][]string{{"-p", "tcp", "-m", "tcp", "--dport", "22", "-j", "LOG", "--log-prefix", "ssh: ", "--log-level", "6"}},
+		},
+		{
+			config.Rule{Protocol: "tcp", Port: 22, Action: "log", LogLevel: "warning"},
+			[][]string{{"-p", "tcp", "-m", "tcp", "--dport", "22", "-j", "LOG"}},
+			[][]string{{"-p", "tcp", "-m", "tcp", "--dport", "22", "-j", "LOG"}},
+		},
+		{
+			config.Rule{Interface: []string{"docker0"}, Action: "accept"},
+			[][]string{{"-i", "docker0", "-j", "ACCEPT"}},
+			[][]string{{"-i", "docker0", "-j", "ACCEPT"}},
+		},
+	}
+
+	for _, test := range tests {
+		f.Equal(test.ipv4, generateRules(test.rule, iptables.ProtocolIPv4))
+		f.Equal(test.ipv6, generateRules(test.rule, iptables.ProtocolIPv6))
+	}
+}
+
 func (f *FirewallTestSuite) Test_GenerateRules() {
 	var tests = []struct {
 		rule     config.Rule
@@ -142,6 +567,19 @@ func (f *FirewallTestSuite) Test_GenerateRules() {
 				{"-p", "udp", "-m", "udp", "--dport", "8080", "-j", "RETURN"},
 			},
 		},
+		{
+			config.Rule{
+				Protocol:     "tcp",
+				Port:         3000,
+				Ports:        []config.PortRange{{From: 8000, To: 8010}},
+				OriginalPort: true,
+				Allow:        []string{"10.0.0.0/8"},
+			},
+			[][]string{
+				{"-s", "10.0.0.0/8", "-p", "tcp", "-m", "conntrack", "--ctorigdstport", "3000", "-j", "RETURN"},
+				{"-s", "10.0.0.0/8", "-p", "tcp", "-m", "conntrack", "--ctorigdstport", "8000:8010", "-j", "RETURN"},
+			},
+		},
 		{
 			config.Rule{
 				Interface: []string{"docker_gwbridge"},
@@ -150,6 +588,17 @@ func (f *FirewallTestSuite) Test_GenerateRules() {
 				{"-i", "docker_gwbridge", "-j", "RETURN"},
 			},
 		},
+		{
+			config.Rule{
+				Protocol: "tcp",
+				Port:     3000,
+				Allow:    []string{"10.0.0.0/8", "!10.0.0.1", "10.0.0.5-10.0.0.50", "!10.1.0.5-10.1.0.50", "fd00::1"},
+			},
+			[][]string{
+				{"-s", "10.0.0.0/8", "-m", "iprange", "!", "--src-range", "10.0.0.1-10.0.0.1", "-m", "iprange", "!", "--src-range", "10.1.0.5-10.1.0.50", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+				{"-m", "iprange", "--src-range", "10.0.0.5-10.0.0.50", "-m", "iprange", "!", "--src-range", "10.0.0.1-10.0.0.1", "-m", "iprange", "!", "--src-range", "10.1.0.5-10.1.0.50", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+			},
+		},
 		{
 			config.Rule{
 				Protocol: "tcp",
@@ -161,9 +610,38 @@ func (f *FirewallTestSuite) Test_GenerateRules() {
 				{"-s", "192.168.10.11", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
 			},
 		},
+		{
+			config.Rule{
+				Protocol: "tcp",
+				Port:     9100,
+			},
+			[][]string{
+				{"-p", "tcp", "-m", "tcp", "--dport", "9100", "-j", "RETURN"},
+			},
+		},
+		{
+			config.Rule{
+				Interface: []string{"eth0"},
+				Ports:     []config.PortRange{{From: 80, To: 80}, {From: 443, To: 443}, {From: 8000, To: 8100}},
+			},
+			[][]string{
+				{"-i", "eth0", "-p", "tcp", "-m", "multiport", "--dports", "80,443,8000:8100", "-j", "RETURN"},
+				{"-i", "eth0", "-p", "udp", "-m", "multiport", "--dports", "80,443,8000:8100", "-j", "RETURN"},
+			},
+		},
+		{
+			config.Rule{
+				Protocol: "udp",
+				Ports:    []config.PortRange{{From: 5000, To: 5010}},
+				Allow:    []string{"10.1.1.1"},
+			},
+			[][]string{
+				{"-s", "10.1.1.1", "-p", "udp", "-m", "udp", "--dport", "5000:5010", "-j", "RETURN"},
+			},
+		},
 	}
 
 	for _, test := range tests {
-		f.Equal(test.expected, generateRules(test.rule))
+		f.Equal(test.expected, generateRules(test.rule, iptables.ProtocolIPv4))
 	}
 }
@@ -0,0 +1,99 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+)
+
+// SetBackend is implemented by the backends able to manage the ipsets the
+// rules match their sources with
+type SetBackend interface {
+	ReplaceSets(sets []compiler.Set) error
+	RemoveSets(prefix string, keep []string) error
+}
+
+// ReplaceSets creates the sets missing with their entries, in a single ipset
+// restore. A set is named after its entries, so an existing set already has
+// them and is left as it is.
+func (i *IPTables) ReplaceSets(sets []compiler.Set) error {
+	if len(sets) == 0 {
+		return nil
+	}
+
+	_, err := runIPSet(strings.NewReader(renderIPSetRestore(sets)), "restore")
+
+	return err
+}
+
+// RemoveSets destroys the sets whose name starts with prefix, except the
+// ones to keep. Nothing is removed when ipset is not installed, since there
+// is no set to remove.
+func (i *IPTables) RemoveSets(prefix string, keep []string) error {
+	if _, err := exec.LookPath("ipset"); err != nil {
+		return nil
+	}
+
+	output, err := runIPSet(nil, "list", "-n")
+	if err != nil {
+		return err
+	}
+
+	kept := map[string]bool{}
+	for _, name := range keep {
+		kept[name] = true
+	}
+
+	for _, name := range strings.Fields(output) {
+		if !strings.HasPrefix(name, prefix) || kept[name] {
+			continue
+		}
+
+		_, err = runIPSet(nil, "destroy", name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runIPSet(stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("ipset", args...)
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("running ipset %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// renderIPSetRestore renders the ipset restore input creating the sets with
+// their entries, leaving the existing ones as they are
+func renderIPSetRestore(sets []compiler.Set) string {
+	var b strings.Builder
+
+	for _, set := range sets {
+		family := "inet"
+		if set.Family == compiler.IPv6 {
+			family = "inet6"
+		}
+
+		fmt.Fprintf(&b, "create %s hash:net family %s -exist\n", set.Name, family)
+		for _, entry := range set.Entries {
+			fmt.Fprintf(&b, "add %s %s -exist\n", set.Name, entry.Value)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,414 @@
+package firewall
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+)
+
+// NFTablesTable is the nftables table managed by the nftables backend
+const NFTablesTable = "docker-firewall"
+
+// nftCommentMaxLen is the maximum length of a nftables rule comment
+const nftCommentMaxLen = 128
+
+var nftHandleRegexp = regexp.MustCompile(`comment "(.*)" # handle (\d+)$`)
+
+// NFTables is a Backend that programs nftables through the nft command.
+// The compiled rules are rendered as nftables expressions, tagged with a
+// comment holding their iptables rule spec, so Exists and List keep the same
+// semantics as the iptables backend. The only rule specs programmed as such
+// are jumps.
+type NFTables struct {
+	family string
+	path   string
+}
+
+// NewNFTables returns a NFTables backend for the given family, ip or ip6.
+// The table and the DOCKER-USER chain hooked into forward are only created
+// when rules are added, so reading the rules leaves the ruleset untouched.
+func NewNFTables(family string) (*NFTables, error) {
+	if family != "ip" && family != "ip6" {
+		return nil, fmt.Errorf("unsupported nftables family %q", family)
+	}
+
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, err
+	}
+
+	return &NFTables{family: family, path: path}, nil
+}
+
+// HooksDockerUser reports the DOCKER-USER chain is hooked into forward by
+// the backend itself
+func (n *NFTables) HooksDockerUser() bool {
+	return true
+}
+
+// Insert inserts the jump rulespec to specified chain in the specified position
+func (n *NFTables) Insert(table, chain string, pos int, rulespec ...string) error {
+	expr, err := n.expression(table, rulespec)
+	if err != nil {
+		return err
+	}
+
+	err = n.ensureChain(chain)
+	if err != nil {
+		return err
+	}
+
+	rules, err := n.rules(chain)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case pos < 1 || pos > len(rules)+1:
+		return fmt.Errorf("invalid rule position %d for chain %s", pos, chain)
+	case pos == len(rules)+1:
+		return n.run(fmt.Sprintf("add rule %s %s %s %s\n", n.family, NFTablesTable, chain, expr))
+	default:
+		return n.run(fmt.Sprintf("insert rule %s %s %s position %d %s\n", n.family, NFTablesTable, chain, rules[pos-1].handle, expr))
+	}
+}
+
+// Append appends the jump rulespec to specified chain
+func (n *NFTables) Append(table, chain string, rulespec ...string) error {
+	expr, err := n.expression(table, rulespec)
+	if err != nil {
+		return err
+	}
+
+	err = n.ensureChain(chain)
+	if err != nil {
+		return err
+	}
+
+	return n.run(fmt.Sprintf("add rule %s %s %s %s\n", n.family, NFTablesTable, chain, expr))
+}
+
+// Delete removes rulespec in specified chain
+func (n *NFTables) Delete(table, chain string, rulespec ...string) error {
+	if table != FilterTable {
+		return fmt.Errorf("unsupported table %q", table)
+	}
+
+	rules, err := n.rules(chain)
+	if err != nil {
+		return err
+	}
+
+	comment := nftComment(rulespec)
+	for _, rule := range rules {
+		if rule.comment == comment {
+			return n.run(fmt.Sprintf("delete rule %s %s %s handle %d\n", n.family, NFTablesTable, chain, rule.handle))
+		}
+	}
+
+	return fmt.Errorf("rule %v does not exist in chain %s", rulespec, chain)
+}
+
+// Exists checks if given rulespec in specified chain exists
+func (n *NFTables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	if table != FilterTable {
+		return false, fmt.Errorf("unsupported table %q", table)
+	}
+
+	rules, err := n.rules(chain)
+	if err != nil {
+		return false, err
+	}
+
+	comment := nftComment(rulespec)
+	for _, rule := range rules {
+		if rule.comment == comment {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// List rules in specified chain, in the same format as iptables -S
+func (n *NFTables) List(table, chain string) ([]string, error) {
+	if table != FilterTable {
+		return nil, fmt.Errorf("unsupported table %q", table)
+	}
+
+	rules, err := n.rules(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{"-N " + chain}
+	for _, rule := range rules {
+		list = append(list, "-A "+chain+" "+rule.comment)
+	}
+
+	return list, nil
+}
+
+// ClearChain flushes the chain, a chain that does not exist having nothing
+// to clear
+func (n *NFTables) ClearChain(table, chain string) error {
+	exists, err := n.ChainExists(table, chain)
+	if err != nil || !exists {
+		return err
+	}
+
+	return n.run(fmt.Sprintf("flush chain %s %s %s\n", n.family, NFTablesTable, chain))
+}
+
+// ChainExists checks if the chain exists in the nftables table
+func (n *NFTables) ChainExists(table, chain string) (bool, error) {
+	if table != FilterTable {
+		return false, fmt.Errorf("unsupported table %q", table)
+	}
+
+	out, err := n.output("list", "tables", n.family)
+	if err != nil {
+		return false, err
+	}
+
+	if !hasNFTTable(out, n.family) {
+		return false, nil
+	}
+
+	out, err = n.output("list", "table", n.family, NFTablesTable)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "chain "+chain+" {" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ReplaceChain atomically replaces the content of the chain with the given
+// jump rules in a single nft transaction
+func (n *NFTables) ReplaceChain(table, chain string, rules [][]string) error {
+	expressions := []string{}
+	for _, rule := range rules {
+		expr, err := n.expression(table, rule)
+		if err != nil {
+			return err
+		}
+		expressions = append(expressions, expr)
+	}
+
+	return n.replace(chain, expressions)
+}
+
+// ReplaceCompiled atomically replaces the content of the chain with the
+// compiled rules in a single nft transaction
+func (n *NFTables) ReplaceCompiled(table, chain string, rules []compiler.Rule) error {
+	if table != FilterTable {
+		return fmt.Errorf("unsupported table %q", table)
+	}
+
+	family := compiler.IPv4
+	if n.family == "ip6" {
+		family = compiler.IPv6
+	}
+
+	expressions := []string{}
+	for _, rule := range rules {
+		specs := compiler.IPTables(rule)
+		for i, expr := range compiler.NFTables(rule, family) {
+			expressions = append(expressions, fmt.Sprintf("%s comment %q", expr, nftComment(specs[i])))
+		}
+	}
+
+	return n.replace(chain, expressions)
+}
+
+// SaveChain returns the rules of the chain as nft lists them, to be put back
+// with RestoreChain
+func (n *NFTables) SaveChain(table, chain string) ([]string, error) {
+	if table != FilterTable {
+		return nil, fmt.Errorf("unsupported table %q", table)
+	}
+
+	exists, err := n.ChainExists(table, chain)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	out, err := n.output("-a", "list", "chain", n.family, NFTablesTable, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNFTSaved(out), nil
+}
+
+// RestoreChain atomically replaces the content of the chain with the rules
+// SaveChain returned
+func (n *NFTables) RestoreChain(table, chain string, saved []string) error {
+	if table != FilterTable {
+		return fmt.Errorf("unsupported table %q", table)
+	}
+
+	return n.replace(chain, saved)
+}
+
+func (n *NFTables) replace(chain string, expressions []string) error {
+	err := n.ensureChain(chain)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "flush chain %s %s %s\n", n.family, NFTablesTable, chain)
+	for _, expr := range expressions {
+		fmt.Fprintf(&b, "add rule %s %s %s %s\n", n.family, NFTablesTable, chain, expr)
+	}
+
+	return n.run(b.String())
+}
+
+// ensureChain creates the table and the chain when missing, DOCKER-USER
+// being a base chain hooked into forward before the chains of docker. A base
+// chain does not replace the others of the hook: drop is final, but accept
+// only ends this table and the traffic still goes through the rules of docker.
+func (n *NFTables) ensureChain(chain string) error {
+	script := fmt.Sprintf("add table %s %s\n", n.family, NFTablesTable)
+	if chain == DockerUserChain {
+		script += fmt.Sprintf("add chain %s %s %s { type filter hook forward priority -1; policy accept; }\n", n.family, NFTablesTable, chain)
+	} else {
+		script += fmt.Sprintf("add chain %s %s %s\n", n.family, NFTablesTable, chain)
+	}
+
+	return n.run(script)
+}
+
+// expression renders a jump rule spec, the other rules being compiled
+func (n *NFTables) expression(table string, rulespec []string) (string, error) {
+	if table != FilterTable {
+		return "", fmt.Errorf("unsupported table %q", table)
+	}
+
+	if len(rulespec) != 2 || rulespec[0] != "-j" {
+		return "", fmt.Errorf("unsupported rule %v, only jumps and compiled rules are programmed", rulespec)
+	}
+
+	return fmt.Sprintf("jump %s comment %q", rulespec[1], nftComment(rulespec)), nil
+}
+
+type nftRule struct {
+	comment string
+	handle  int
+}
+
+// rules returns the rules of the chain, none when it does not exist
+func (n *NFTables) rules(chain string) ([]nftRule, error) {
+	exists, err := n.ChainExists(FilterTable, chain)
+	if err != nil || !exists {
+		return []nftRule{}, err
+	}
+
+	out, err := n.output("-a", "list", "chain", n.family, NFTablesTable, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNFTRules(out), nil
+}
+
+// hasNFTTable reports if a listing of the tables of the family has the
+// table of the backend
+func hasNFTTable(output, family string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "table "+family+" "+NFTablesTable {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseNFTRules(output string) []nftRule {
+	rules := []nftRule{}
+	for _, line := range strings.Split(output, "\n") {
+		match := nftHandleRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		handle, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+
+		rules = append(rules, nftRule{comment: match[1], handle: handle})
+	}
+
+	return rules
+}
+
+// parseNFTSaved returns the rules of a chain listing without their handle
+func parseNFTSaved(output string) []string {
+	saved := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if nftHandleRegexp.MatchString(line) {
+			saved = append(saved, line[:strings.LastIndex(line, " # handle ")])
+		}
+	}
+
+	return saved
+}
+
+func (n *NFTables) run(script string) error {
+	cmd := exec.Command(n.path, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("running nft failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func (n *NFTables) output(args ...string) (string, error) {
+	cmd := exec.Command(n.path, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("running nft %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// nftComment returns the comment identifying a rule spec, long specs are
+// truncated and suffixed with a hash to fit the nftables comment limit
+func nftComment(rulespec []string) string {
+	comment := strings.Join(rulespec, " ")
+	if len(comment) <= nftCommentMaxLen {
+		return comment
+	}
+
+	sum := fmt.Sprintf("%x", sha1.Sum([]byte(comment)))[:12]
+
+	return comment[:nftCommentMaxLen-len(sum)-1] + "#" + sum
+}
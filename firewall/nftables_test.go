@@ -0,0 +1,85 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type NFTablesTestSuite struct {
+	suite.Suite
+}
+
+func TestNFTablesTestSuite(t *testing.T) {
+	suite.Run(t, new(NFTablesTestSuite))
+}
+
+func (n *NFTablesTestSuite) Test_Expression() {
+	nft := &NFTables{family: "ip"}
+
+	expr, err := nft.expression(FilterTable, []string{"-j", FirewallChain})
+	n.NoError(err)
+	n.Equal(`jump DOCKER-FIREWALL comment "-j DOCKER-FIREWALL"`, expr)
+
+	_, err = nft.expression(FilterTable, []string{"-p", "tcp", "-j", "RETURN"})
+	n.EqualError(err, "unsupported rule [-p tcp -j RETURN], only jumps and compiled rules are programmed")
+
+	_, err = nft.expression("nat", []string{"-j", FirewallChain})
+	n.EqualError(err, `unsupported table "nat"`)
+}
+
+func (n *NFTablesTestSuite) Test_NFTComment() {
+	n.Equal("-p tcp -m tcp --dport 8080 -j RETURN", nftComment([]string{"-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"}))
+
+	long := []string{"-s", strings.Repeat("1", 200), "-j", "RETURN"}
+	comment := nftComment(long)
+	n.Len(comment, nftCommentMaxLen)
+	n.Equal(comment, nftComment(long))
+}
+
+func (n *NFTablesTestSuite) Test_HasNFTTable() {
+	output := "table ip nat\ntable ip filter\ntable ip docker-firewall\n"
+
+	n.True(hasNFTTable(output, "ip"))
+	n.False(hasNFTTable(output, "ip6"))
+	n.False(hasNFTTable("table ip nat\n", "ip"))
+	n.False(hasNFTTable("", "ip"))
+}
+
+func (n *NFTablesTestSuite) Test_ParseNFTRules() {
+	output := `table ip docker-firewall {
+	chain DOCKER-USER { # handle 1
+		type filter hook forward priority -1; policy accept;
+		ct state established,related return comment "-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN" # handle 4
+		tcp dport 8080 return comment "-p tcp -m tcp --dport 8080 -j RETURN" # handle 5
+		drop comment "-j DROP" # handle 6
+	}
+}
+`
+
+	expected := []nftRule{
+		{comment: "-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN", handle: 4},
+		{comment: "-p tcp -m tcp --dport 8080 -j RETURN", handle: 5},
+		{comment: "-j DROP", handle: 6},
+	}
+
+	n.Equal(expected, parseNFTRules(output))
+}
+
+func (n *NFTablesTestSuite) Test_ParseNFTSaved() {
+	output := `table ip docker-firewall {
+	chain DOCKER-FIREWALL { # handle 2
+		ct state established,related return comment "-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN" # handle 7
+		iifname "eth0" tcp dport { 80, 443 } return comment "-i eth0 -p tcp -m multiport --dports 80,443 -j RETURN" # handle 8
+		drop comment "-j DROP" # handle 9
+	}
+}
+`
+
+	n.Equal([]string{
+		`ct state established,related return comment "-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN"`,
+		`iifname "eth0" tcp dport { 80, 443 } return comment "-i eth0 -p tcp -m multiport --dports 80,443 -j RETURN"`,
+		`drop comment "-j DROP"`,
+	}, parseNFTSaved(output))
+}
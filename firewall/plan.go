@@ -0,0 +1,303 @@
+package firewall
+
+import (
+	"net"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// ChangeType defines how a rule changes between the applied and the planned chain
+type ChangeType string
+
+const (
+	// Unchanged rules are both applied and planned
+	Unchanged ChangeType = " "
+	// Added rules are planned but not applied
+	Added ChangeType = "+"
+	// Removed rules are applied but not planned
+	Removed ChangeType = "-"
+)
+
+// Change defines a rule in the plan of a family
+type Change struct {
+	Type ChangeType
+	Rule string
+}
+
+// Plan defines the changes Apply would make to the chain of a family
+type Plan struct {
+	Family  string
+	Changes []Change
+}
+
+// HasDrift reports if applying the plan would change the chain
+func (p Plan) HasDrift() bool {
+	for _, change := range p.Changes {
+		if change.Type != Unchanged {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Counts returns the number of rules the plan adds and removes
+func (p Plan) Counts() (added, removed int) {
+	for _, change := range p.Changes {
+		switch change.Type {
+		case Added:
+			added++
+		case Removed:
+			removed++
+		}
+	}
+
+	return added, removed
+}
+
+// Plan compares the rules currently in the DOCKER-FIREWALL chain with the
+// rules Apply would render, returning one plan per family Apply manages. The
+// plan starts with the jump from DOCKER-USER.
+func (f *Firewall) Plan(rules []config.Rule) ([]Plan, error) {
+	plans := []Plan{}
+
+	for _, family := range f.families {
+		hooked, err := hooked(family.backend)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hooked {
+			continue
+		}
+
+		changes := []Change{}
+
+		jump := "-A " + DockerUserChain + " " + normalizeRuleSpec(jumpRule)
+		exists, err := family.backend.Exists(FilterTable, DockerUserChain, jumpRule...)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			changes = append(changes, Change{Type: Unchanged, Rule: jump})
+		} else {
+			changes = append(changes, Change{Type: Added, Rule: jump})
+		}
+
+		prefix := "-A " + FirewallChain + " "
+
+		current := []string{}
+		exists, err = family.backend.ChainExists(FilterTable, FirewallChain)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			list, err := family.backend.List(FilterTable, FirewallChain)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, line := range list {
+				if strings.HasPrefix(line, prefix) {
+					current = append(current, prefix+normalizeRuleSpec(splitRuleSpec(strings.TrimPrefix(line, prefix))))
+				}
+			}
+		}
+
+		planned := []string{}
+		for _, rule := range f.chainRules(rules, family.proto) {
+			planned = append(planned, prefix+normalizeRuleSpec(rule))
+		}
+
+		changes = append(changes, diffRules(current, planned)...)
+		plans = append(plans, Plan{Family: protocolName(family.proto), Changes: changes})
+	}
+
+	return plans, nil
+}
+
+// diffRules returns the changes turning the current rules into the planned
+// ones, based on their longest common subsequence
+func diffRules(current, planned []string) []Change {
+	lcs := make([][]int, len(current)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(planned)+1)
+	}
+
+	for i := len(current) - 1; i >= 0; i-- {
+		for j := len(planned) - 1; j >= 0; j-- {
+			if current[i] == planned[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	changes := []Change{}
+	i, j := 0, 0
+	for i < len(current) && j < len(planned) {
+		switch {
+		case current[i] == planned[j]:
+			changes = append(changes, Change{Type: Unchanged, Rule: current[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			changes = append(changes, Change{Type: Removed, Rule: current[i]})
+			i++
+		default:
+			changes = append(changes, Change{Type: Added, Rule: planned[j]})
+			j++
+		}
+	}
+
+	for ; i < len(current); i++ {
+		changes = append(changes, Change{Type: Removed, Rule: current[i]})
+	}
+
+	for ; j < len(planned); j++ {
+		changes = append(changes, Change{Type: Added, Rule: planned[j]})
+	}
+
+	return changes
+}
+
+// basicOptions are the options iptables -S prints before the matches, in order
+var basicOptions = []string{"-s", "-d", "-i", "-o", "-p"}
+
+// normalizeRuleSpec renders a rule spec the way iptables -S prints it, so
+// rendered rules can be compared with listed ones
+func normalizeRuleSpec(rulespec []string) string {
+	basic := map[string][]string{}
+	matches := []string{}
+	target := []string{}
+	negate := false
+
+	for i := 0; i < len(rulespec); i++ {
+		arg := rulespec[i]
+
+		if arg == "!" {
+			negate = true
+			continue
+		}
+
+		option := []string{}
+		if negate {
+			option = append(option, "!")
+			negate = false
+		}
+
+		switch {
+		case isBasicOption(arg) && i+1 < len(rulespec):
+			value := rulespec[i+1]
+			i++
+			switch arg {
+			case "-s", "-d":
+				value = normalizeAddress(value)
+			case "-p":
+				value = normalizeProtocol(value)
+				// iptables -S omits the protocol matching any
+				if value == "all" && len(option) == 0 {
+					continue
+				}
+			}
+			basic[arg] = append(option, arg, value)
+		case arg == "-j" || len(target) > 0:
+			target = append(target, option...)
+			target = append(target, quoteRestoreArg(arg))
+		default:
+			matches = append(matches, option...)
+			matches = append(matches, quoteRestoreArg(arg))
+		}
+	}
+
+	normalized := []string{}
+	for _, option := range basicOptions {
+		normalized = append(normalized, basic[option]...)
+	}
+	normalized = append(normalized, matches...)
+	normalized = append(normalized, target...)
+
+	return strings.Join(normalized, " ")
+}
+
+func isBasicOption(arg string) bool {
+	for _, option := range basicOptions {
+		if arg == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeAddress returns an address with its prefix length, as listed by iptables
+// normalizeProtocol names a protocol the way iptables -S prints it
+func normalizeProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "icmpv6", "ipv6-icmp", "58":
+		return "ipv6-icmp"
+	case "all", "0":
+		return "all"
+	}
+
+	return protocol
+}
+
+func normalizeAddress(address string) string {
+	if _, network, err := net.ParseCIDR(address); err == nil {
+		return network.String()
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return address
+	}
+
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+
+	return ip.String() + "/128"
+}
+
+// splitRuleSpec splits a listed rule into its arguments, honouring quotes
+func splitRuleSpec(rule string) []string {
+	args := []string{}
+	var current strings.Builder
+	quoted := false
+	inArg := false
+
+	for i := 0; i < len(rule); i++ {
+		c := rule[i]
+
+		switch {
+		case c == '\\' && quoted && i+1 < len(rule):
+			i++
+			current.WriteByte(rule[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
@@ -0,0 +1,171 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/firewall/fake"
+	"github.com/stretchr/testify/suite"
+)
+
+type PlanTestSuite struct {
+	suite.Suite
+	backend *fake.Backend
+}
+
+func TestPlanTestSuite(t *testing.T) {
+	suite.Run(t, new(PlanTestSuite))
+}
+
+func (p *PlanTestSuite) SetupTest() {
+	p.backend = fake.New()
+}
+
+func (p *PlanTestSuite) Test_Plan() {
+	rules := []config.Rule{
+		{
+			Protocol: "tcp",
+			Port:     3000,
+			Allow:    []string{"10.1.1.1"},
+		},
+		{
+			Protocol: "tcp",
+			Port:     9100,
+		},
+	}
+
+	// rules as listed by iptables -S after a previous apply
+	err := p.backend.ReplaceChain(FilterTable, FirewallChain, [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.1.1.1/32", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+		{"-s", "10.2.2.2/32", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+		{"-j", "DROP"},
+	})
+	p.NoError(err)
+
+	firewall := NewFirewallWithBackend(p.backend)
+
+	plans, err := firewall.Plan(rules)
+	p.NoError(err)
+
+	expected := []Plan{
+		{
+			Family: "IPv4",
+			Changes: []Change{
+				{Type: Added, Rule: "-A DOCKER-USER -j DOCKER-FIREWALL"},
+				{Type: Unchanged, Rule: "-A DOCKER-FIREWALL -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN"},
+				{Type: Unchanged, Rule: "-A DOCKER-FIREWALL -s 10.1.1.1/32 -p tcp -m tcp --dport 3000 -j RETURN"},
+				{Type: Removed, Rule: "-A DOCKER-FIREWALL -s 10.2.2.2/32 -p tcp -m tcp --dport 3000 -j RETURN"},
+				{Type: Added, Rule: "-A DOCKER-FIREWALL -p tcp -m tcp --dport 9100 -j RETURN"},
+				{Type: Unchanged, Rule: "-A DOCKER-FIREWALL -j DROP"},
+			},
+		},
+	}
+	p.Equal(expected, plans)
+	p.True(plans[0].HasDrift())
+
+	added, removed := plans[0].Counts()
+	p.Equal(2, added)
+	p.Equal(1, removed)
+
+	err = firewall.Apply(rules)
+	p.NoError(err)
+
+	plans, err = firewall.Plan(rules)
+	p.NoError(err)
+	p.False(plans[0].HasDrift())
+}
+
+func (p *PlanTestSuite) Test_Plan_NoChain() {
+	firewall := NewFirewallWithBackend(p.backend)
+
+	plans, err := firewall.Plan([]config.Rule{})
+	p.NoError(err)
+
+	expected := []Change{
+		{Type: Added, Rule: "-A DOCKER-USER -j DOCKER-FIREWALL"},
+		{Type: Added, Rule: "-A DOCKER-FIREWALL -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN"},
+		{Type: Added, Rule: "-A DOCKER-FIREWALL -j DROP"},
+	}
+	p.Equal(expected, plans[0].Changes)
+}
+
+func (p *PlanTestSuite) Test_Plan_ProtocolNames() {
+	ipv6 := fake.New()
+	rules := []config.Rule{
+		{Protocol: "all", Allow: []string{"10.1.1.1", "fd00::1"}},
+		{Protocol: "icmpv6"},
+	}
+
+	firewall := NewFirewallWithBackends(p.backend, ipv6)
+	p.Require().NoError(firewall.Apply(rules))
+
+	// rules as listed by iptables -S, without -p all and with -p ipv6-icmp
+	p.Require().NoError(p.backend.ReplaceChain(FilterTable, FirewallChain, [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "10.1.1.1/32", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}))
+	p.Require().NoError(ipv6.ReplaceChain(FilterTable, FirewallChain, [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "fd00::1/128", "-j", "RETURN"},
+		{"-p", "ipv6-icmp", "-m", "icmp6", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}))
+
+	plans, err := firewall.Plan(rules)
+	p.Require().NoError(err)
+	p.Len(plans, 2)
+	for _, plan := range plans {
+		p.False(plan.HasDrift(), plan.Family)
+	}
+}
+
+func (p *PlanTestSuite) Test_NormalizeRuleSpec() {
+	var tests = []struct {
+		rulespec []string
+		expected string
+	}{
+		{
+			[]string{"-s", "10.1.1.1", "-i", "eth0", "-p", "tcp", "-m", "tcp", "--dport", "8080", "-j", "RETURN"},
+			"-s 10.1.1.1/32 -i eth0 -p tcp -m tcp --dport 8080 -j RETURN",
+		},
+		{
+			[]string{"!", "-s", "10.1.2.3/8", "-p", "udp", "-m", "udp", "-j", "RETURN"},
+			"! -s 10.0.0.0/8 -p udp -m udp -j RETURN",
+		},
+		{
+			[]string{"-m", "iprange", "!", "--src-range", "10.0.0.5-10.0.0.50", "-i", "eth0", "-p", "tcp", "-m", "tcp", "--dport", "3000", "-j", "RETURN"},
+			"-i eth0 -p tcp -m iprange ! --src-range 10.0.0.5-10.0.0.50 -m tcp --dport 3000 -j RETURN",
+		},
+		{
+			[]string{"-s", "fd00::1", "-j", "LOG", "--log-prefix", "docker firewall: "},
+			`-s fd00::1/128 -j LOG --log-prefix "docker firewall: "`,
+		},
+		{[]string{"-s", "10.1.1.1", "-p", "all", "-j", "RETURN"}, "-s 10.1.1.1/32 -j RETURN"},
+		{[]string{"-p", "icmpv6", "-j", "RETURN"}, "-p ipv6-icmp -j RETURN"},
+		{[]string{"-p", "58", "-j", "RETURN"}, "-p ipv6-icmp -j RETURN"},
+		{[]string{"-p", "ipv6-icmp", "-j", "RETURN"}, "-p ipv6-icmp -j RETURN"},
+	}
+
+	for _, test := range tests {
+		p.Equal(test.expected, normalizeRuleSpec(test.rulespec))
+	}
+}
+
+func (p *PlanTestSuite) Test_SplitRuleSpec() {
+	p.Equal([]string{"-s", "10.1.1.1/32", "-j", "RETURN"}, splitRuleSpec("-s 10.1.1.1/32 -j RETURN"))
+	p.Equal([]string{"-j", "LOG", "--log-prefix", `say "hi" `}, splitRuleSpec(`-j LOG --log-prefix "say \"hi\" "`))
+}
+
+func (p *PlanTestSuite) Test_DiffRules() {
+	changes := diffRules([]string{"a", "b", "c"}, []string{"a", "c", "d"})
+
+	expected := []Change{
+		{Type: Unchanged, Rule: "a"},
+		{Type: Removed, Rule: "b"},
+		{Type: Unchanged, Rule: "c"},
+		{Type: Added, Rule: "d"},
+	}
+	p.Equal(expected, changes)
+}
@@ -0,0 +1,144 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Snapshot defines the DOCKER-FIREWALL rules of each IP protocol and whether
+// DOCKER-USER jumped to them, as they were when it was taken. The content of
+// the ipsets is not saved, sets tells the rules matched them.
+type Snapshot struct {
+	families []familySnapshot
+	sets     bool
+}
+
+// familySnapshot is skipped when DOCKER-USER was not hooked, as Apply does.
+// The rules of a SavingBackend are also saved in its own syntax.
+type familySnapshot struct {
+	skipped bool
+	jump    bool
+	rules   [][]string
+	saved   []string
+}
+
+// SavingBackend is implemented by the backends whose rules can not be
+// programmed again from their iptables rule specs, saving and restoring a
+// chain in their own syntax instead
+type SavingBackend interface {
+	SaveChain(table, chain string) ([]string, error)
+	RestoreChain(table, chain string, saved []string) error
+}
+
+// Rules returns the number of rules in the snapshot
+func (s *Snapshot) Rules() int {
+	count := 0
+	for _, family := range s.families {
+		count += len(family.rules)
+	}
+
+	return count
+}
+
+// Snapshot returns the rules currently applied, to be put back with Restore
+func (f *Firewall) Snapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{sets: f.ipset}
+	prefix := "-A " + FirewallChain + " "
+
+	for _, family := range f.families {
+		hooked, err := hooked(family.backend)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hooked {
+			snapshot.families = append(snapshot.families, familySnapshot{skipped: true})
+			continue
+		}
+
+		jump, err := family.backend.Exists(FilterTable, DockerUserChain, jumpRule...)
+		if err != nil {
+			return nil, err
+		}
+
+		exists, err := family.backend.ChainExists(FilterTable, FirewallChain)
+		if err != nil {
+			return nil, err
+		}
+
+		rules := [][]string{}
+		if exists {
+			list, err := family.backend.List(FilterTable, FirewallChain)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the %s rules: %v", protocolName(family.proto), err)
+			}
+
+			for _, line := range list {
+				if strings.HasPrefix(line, prefix) {
+					rules = append(rules, splitRuleSpec(strings.TrimPrefix(line, prefix)))
+				}
+			}
+		}
+
+		var saved []string
+		if backend, ok := family.backend.(SavingBackend); ok {
+			saved, err = backend.SaveChain(FilterTable, FirewallChain)
+			if err != nil {
+				return nil, fmt.Errorf("error saving the %s rules: %v", protocolName(family.proto), err)
+			}
+		}
+
+		snapshot.families = append(snapshot.families, familySnapshot{jump: jump, rules: rules, saved: saved})
+	}
+
+	return snapshot, nil
+}
+
+// Restore puts back the rules of the snapshot. The whole DOCKER-FIREWALL
+// chain is replaced at once, and the jump from DOCKER-USER is added or
+// removed as it was. The rules matching ipsets can not be restored, since
+// the sets were replaced since.
+func (f *Firewall) Restore(snapshot *Snapshot) error {
+	if snapshot.sets {
+		return errors.New("the rules matched ipsets, whose entries were not saved")
+	}
+
+	if len(snapshot.families) != len(f.families) {
+		return fmt.Errorf("the snapshot has %d IP protocols instead of %d", len(snapshot.families), len(f.families))
+	}
+
+	for i, family := range f.families {
+		saved := snapshot.families[i]
+		if saved.skipped {
+			continue
+		}
+
+		var err error
+		if backend, ok := family.backend.(SavingBackend); ok {
+			err = backend.RestoreChain(FilterTable, FirewallChain, saved.saved)
+		} else {
+			err = family.backend.ReplaceChain(FilterTable, FirewallChain, saved.rules)
+		}
+		if err != nil {
+			return fmt.Errorf("error restoring %s rules: %v", protocolName(family.proto), err)
+		}
+
+		exists, err := family.backend.Exists(FilterTable, DockerUserChain, jumpRule...)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case saved.jump && !exists:
+			err = family.backend.Insert(FilterTable, DockerUserChain, 1, jumpRule...)
+		case !saved.jump && exists:
+			err = family.backend.Delete(FilterTable, DockerUserChain, jumpRule...)
+		}
+		if err != nil {
+			return fmt.Errorf("error restoring the %s jump to %s: %v", protocolName(family.proto), FirewallChain, err)
+		}
+	}
+
+	return nil
+}
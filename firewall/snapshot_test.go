@@ -0,0 +1,136 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/albertogviana/docker-firewall/firewall/fake"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/suite"
+)
+
+type SnapshotTestSuite struct {
+	suite.Suite
+	backend  *fake.Backend
+	firewall *Firewall
+}
+
+func TestSnapshotTestSuite(t *testing.T) {
+	suite.Run(t, new(SnapshotTestSuite))
+}
+
+func (s *SnapshotTestSuite) SetupTest() {
+	s.backend = fake.New()
+	s.firewall = NewFirewallWithBackend(s.backend)
+}
+
+func (s *SnapshotTestSuite) Test_Restore() {
+	confirmed := []config.Rule{{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.0/8"}}}
+	s.Require().NoError(s.firewall.Apply(confirmed))
+	s.Require().NoError(s.backend.Insert(FilterTable, DockerUserChain, 1, "-s", "172.17.0.5", "-j", "DROP"))
+
+	snapshot, err := s.firewall.Snapshot()
+	s.Require().NoError(err)
+	s.Equal(3, snapshot.Rules())
+
+	s.Require().NoError(s.firewall.Apply([]config.Rule{{Protocol: "tcp", Port: 8080}}))
+	s.Require().NoError(s.backend.Delete(FilterTable, DockerUserChain, jumpRule...))
+
+	s.Require().NoError(s.firewall.Restore(snapshot))
+	s.Equal(s.firewall.chainRules(confirmed, iptables.ProtocolIPv4), s.backend.Rules(FilterTable, FirewallChain))
+	s.Equal([][]string{
+		{"-j", FirewallChain},
+		{"-s", "172.17.0.5", "-j", "DROP"},
+		{"-j", "RETURN"},
+	}, s.backend.Rules(FilterTable, DockerUserChain))
+
+	verified, err := s.firewall.Verify(confirmed)
+	s.NoError(err)
+	s.True(verified)
+}
+
+func (s *SnapshotTestSuite) Test_Restore_NotApplied() {
+	snapshot, err := s.firewall.Snapshot()
+	s.Require().NoError(err)
+	s.Equal(0, snapshot.Rules())
+
+	s.Require().NoError(s.firewall.Apply([]config.Rule{{Protocol: "tcp", Port: 8080}}))
+	s.Require().NoError(s.firewall.Restore(snapshot))
+
+	s.Empty(s.backend.Rules(FilterTable, FirewallChain))
+	s.Equal([][]string{{"-j", "RETURN"}}, s.backend.Rules(FilterTable, DockerUserChain))
+}
+
+// compiledBackend is a fake backend programming the compiled rules as their
+// nftables expressions, saved and restored as they are
+type compiledBackend struct {
+	*fake.Backend
+}
+
+func (c compiledBackend) ReplaceCompiled(table, chain string, rules []compiler.Rule) error {
+	expressions := [][]string{}
+	for _, rule := range rules {
+		for _, expr := range compiler.NFTables(rule, compiler.IPv4) {
+			expressions = append(expressions, []string{expr})
+		}
+	}
+
+	return c.ReplaceChain(table, chain, expressions)
+}
+
+func (c compiledBackend) SaveChain(table, chain string) ([]string, error) {
+	saved := []string{}
+	for _, rule := range c.Rules(table, chain) {
+		saved = append(saved, rule[0])
+	}
+
+	return saved, nil
+}
+
+func (c compiledBackend) RestoreChain(table, chain string, saved []string) error {
+	rules := [][]string{}
+	for _, expr := range saved {
+		rules = append(rules, []string{expr})
+	}
+
+	return c.ReplaceChain(table, chain, rules)
+}
+
+func (s *SnapshotTestSuite) Test_Restore_Compiled() {
+	firewall := NewFirewallWithBackend(compiledBackend{s.backend})
+	s.Require().NoError(firewall.Apply([]config.Rule{{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.0/8"}}}))
+
+	expected := [][]string{
+		{"ct state related,established return"},
+		{"ip saddr 10.0.0.0/8 tcp dport 22 return"},
+		{"drop"},
+	}
+	s.Equal(expected, s.backend.Rules(FilterTable, FirewallChain))
+
+	snapshot, err := firewall.Snapshot()
+	s.Require().NoError(err)
+
+	s.Require().NoError(firewall.Apply([]config.Rule{{Protocol: "tcp", Port: 8080}}))
+	s.Require().NoError(firewall.Restore(snapshot))
+	s.Equal(expected, s.backend.Rules(FilterTable, FirewallChain))
+}
+
+func (s *SnapshotTestSuite) Test_Restore_OtherFamilies() {
+	snapshot, err := s.firewall.Snapshot()
+	s.Require().NoError(err)
+
+	firewall := NewFirewallWithBackends(fake.New(), fake.New())
+	s.EqualError(firewall.Restore(snapshot), "the snapshot has 1 IP protocols instead of 2")
+}
+
+func (s *SnapshotTestSuite) Test_Restore_IPSet() {
+	s.firewall.SetIPSet(true)
+	s.Require().NoError(s.firewall.Apply([]config.Rule{{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.0/8"}}}))
+
+	snapshot, err := s.firewall.Snapshot()
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.firewall.Apply([]config.Rule{{Protocol: "tcp", Port: 22, Allow: []string{"192.168.0.0/16"}}}))
+	s.EqualError(s.firewall.Restore(snapshot), "the rules matched ipsets, whose entries were not saved")
+}
@@ -0,0 +1,225 @@
+// Package lint analyses the compiled rules of a configuration, reporting the
+// rules that can never decide any traffic, or not the way they read
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/albertogviana/docker-firewall/config"
+)
+
+// Severity defines how much a finding matters
+type Severity string
+
+const (
+	// Error findings are rules that do not do what they say
+	Error Severity = "error"
+	// Warning findings are rules that are useless or depend on the host
+	Warning Severity = "warning"
+)
+
+// Checks reported in the findings
+const (
+	// Shadowed rules are decided differently by earlier rules
+	Shadowed = "shadowed"
+	// Redundant rules are decided the same way by earlier rules
+	Redundant = "redundant"
+	// Contradiction rules decide the exact traffic of an earlier rule differently
+	Contradiction = "contradiction"
+	// MissingInterface rules match an interface the host does not have
+	MissingInterface = "missing-interface"
+	// NeverMatch rules compile to no rule at all
+	NeverMatch = "never-match"
+)
+
+// Finding is an issue found in a configuration rule, Rule being its index
+type Finding struct {
+	Severity Severity
+	Check    string
+	Rule     int
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: rule %d: %s (%s)", f.Severity, f.Rule, f.Message, f.Check)
+}
+
+// Lint analyses the rules compiled for the given families. The interfaces
+// of the rules are checked against the given ones, unless they are nil.
+func Lint(rules []config.Rule, families []compiler.Family, interfaces []string) []Finding {
+	findings := []Finding{}
+
+	chains := [][]compiler.Rule{}
+	for _, family := range families {
+		chains = append(chains, compiler.Chain(rules, family, compiler.Policy{}))
+	}
+
+	for i, rule := range rules {
+		if interfaces != nil {
+			findings = append(findings, missingInterfaces(i, rule, interfaces)...)
+		}
+
+		if finding, ok := overlap(i, chains); ok {
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Rule < findings[j].Rule })
+
+	return findings
+}
+
+// overlap reports the configuration rule that never matches, or whose traffic
+// is always decided by earlier rules
+func overlap(origin int, chains [][]compiler.Rule) (Finding, bool) {
+	compiled := 0
+	covered := 0
+	coverers := map[int]compiler.Rule{}
+	contradicted := map[int]compiler.Rule{}
+	differ := false
+
+	for _, chain := range chains {
+		for position, rule := range chain {
+			if rule.Origin != origin {
+				continue
+			}
+			compiled++
+
+			coverer, ok := firstCoverer(chain[:position], origin, rule.Match)
+			if !ok {
+				continue
+			}
+			covered++
+
+			coverers[coverer.Origin] = coverer
+			if coverer.Action.Verdict != rule.Action.Verdict {
+				differ = true
+				if equalTuple(coverer.Match, rule.Match) {
+					contradicted[coverer.Origin] = coverer
+				}
+			}
+		}
+	}
+
+	switch {
+	case compiled == 0:
+		return Finding{
+			Severity: Error,
+			Check:    NeverMatch,
+			Rule:     origin,
+			Message:  "never matches, its protocol does not apply to any of its allow entries or of the managed IP protocols",
+		}, true
+	case covered < compiled:
+		return Finding{}, false
+	case len(contradicted) > 0:
+		return Finding{
+			Severity: Error,
+			Check:    Contradiction,
+			Rule:     origin,
+			Message:  fmt.Sprintf("matches the same traffic as %s, decided first with %s", ruleList(contradicted), verdicts(contradicted)),
+		}, true
+	case differ:
+		return Finding{
+			Severity: Error,
+			Check:    Shadowed,
+			Rule:     origin,
+			Message:  fmt.Sprintf("never decides any traffic, it is decided first by %s with %s", ruleList(coverers), verdicts(coverers)),
+		}, true
+	default:
+		return Finding{
+			Severity: Warning,
+			Check:    Redundant,
+			Rule:     origin,
+			Message:  fmt.Sprintf("is redundant, its traffic is already decided by %s with %s", ruleList(coverers), verdicts(coverers)),
+		}, true
+	}
+}
+
+// firstCoverer returns the first terminating configuration rule of another
+// origin covering the match
+func firstCoverer(chain []compiler.Rule, origin int, match compiler.Match) (compiler.Rule, bool) {
+	for _, rule := range chain {
+		if rule.Origin >= 0 && rule.Origin != origin && rule.Action.Terminates() && rule.Match.Covers(match) {
+			return rule, true
+		}
+	}
+
+	return compiler.Rule{}, false
+}
+
+// equalTuple reports if two matches match the same traffic
+func equalTuple(match, other compiler.Match) bool {
+	return match.Covers(other) && other.Covers(match)
+}
+
+// ruleList prints the indexes of the rules, in order
+func ruleList(rules map[int]compiler.Rule) string {
+	origins := sortedOrigins(rules)
+
+	printed := []string{}
+	for _, origin := range origins {
+		printed = append(printed, fmt.Sprint(origin))
+	}
+
+	if len(printed) == 1 {
+		return "rule " + printed[0]
+	}
+
+	return "rules " + strings.Join(printed, ", ")
+}
+
+// verdicts prints the distinct verdicts of the rules, in the order of the rules
+func verdicts(rules map[int]compiler.Rule) string {
+	printed := []string{}
+	seen := map[string]bool{}
+
+	for _, origin := range sortedOrigins(rules) {
+		verdict := rules[origin].Action.Verdict
+		if !seen[verdict] {
+			seen[verdict] = true
+			printed = append(printed, verdict)
+		}
+	}
+
+	return strings.Join(printed, " and ")
+}
+
+func sortedOrigins(rules map[int]compiler.Rule) []int {
+	origins := []int{}
+	for origin := range rules {
+		origins = append(origins, origin)
+	}
+	sort.Ints(origins)
+
+	return origins
+}
+
+// missingInterfaces reports the interfaces of a rule matching none of the
+// host interfaces, a trailing + matching every interface with that prefix
+func missingInterfaces(origin int, rule config.Rule, interfaces []string) []Finding {
+	findings := []Finding{}
+
+	for _, name := range rule.Interface {
+		found := false
+		for _, iface := range interfaces {
+			if name == iface || strings.HasSuffix(name, "+") && strings.HasPrefix(iface, strings.TrimSuffix(name, "+")) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			findings = append(findings, Finding{
+				Severity: Warning,
+				Check:    MissingInterface,
+				Rule:     origin,
+				Message:  fmt.Sprintf("interface %q does not exist on this host", name),
+			})
+		}
+	}
+
+	return findings
+}
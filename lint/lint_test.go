@@ -0,0 +1,112 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/albertogviana/docker-firewall/compiler"
+	"github.com/albertogviana/docker-firewall/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type LintTestSuite struct {
+	suite.Suite
+	families []compiler.Family
+}
+
+func TestLintTestSuite(t *testing.T) {
+	suite.Run(t, new(LintTestSuite))
+}
+
+func (l *LintTestSuite) SetupTest() {
+	l.families = []compiler.Family{compiler.IPv4, compiler.IPv6}
+}
+
+func (l *LintTestSuite) Test_Lint_Redundant() {
+	findings := Lint([]config.Rule{
+		{Port: 5601},
+		{Port: 5601, Allow: []string{"10.0.0.0/8"}},
+	}, l.families, nil)
+
+	l.Equal([]Finding{{
+		Severity: Warning,
+		Check:    Redundant,
+		Rule:     1,
+		Message:  "is redundant, its traffic is already decided by rule 0 with return",
+	}}, findings)
+}
+
+func (l *LintTestSuite) Test_Lint_Shadowed() {
+	findings := Lint([]config.Rule{
+		{Protocol: "tcp", Ports: []config.PortRange{{From: 8000, To: 9000}}},
+		{Protocol: "tcp", Port: 8443, Allow: []string{"203.0.113.0/24"}, Action: config.ActionDrop},
+	}, l.families, nil)
+
+	l.Len(findings, 1)
+	l.Equal(Shadowed, findings[0].Check)
+	l.Equal(Error, findings[0].Severity)
+	l.Equal("error: rule 1: never decides any traffic, it is decided first by rule 0 with return (shadowed)", findings[0].String())
+}
+
+func (l *LintTestSuite) Test_Lint_Contradiction() {
+	findings := Lint([]config.Rule{
+		{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.0/24"}, Action: config.ActionDrop},
+		{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.0/24"}},
+	}, l.families, nil)
+
+	l.Equal([]Finding{{
+		Severity: Error,
+		Check:    Contradiction,
+		Rule:     1,
+		Message:  "matches the same traffic as rule 0, decided first with drop",
+	}}, findings)
+}
+
+func (l *LintTestSuite) Test_Lint_Intended() {
+	findings := Lint([]config.Rule{
+		{Protocol: "tcp", Port: 443, Allow: []string{"203.0.113.0/24"}, Action: config.ActionReject},
+		{Protocol: "tcp", Port: 443},
+		{Protocol: "tcp", Port: 22, Action: config.ActionLog},
+		{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.1", "192.168.1.1"}},
+	}, l.families, nil)
+
+	l.Empty(findings)
+}
+
+func (l *LintTestSuite) Test_Lint_AcrossFamilies() {
+	findings := Lint([]config.Rule{
+		{Protocol: "tcp", Port: 22, Allow: []string{"10.0.0.0/8"}},
+		{Protocol: "tcp", Port: 22, Allow: []string{"2001:db8::/32"}},
+		{Protocol: "tcp", Port: 22, Allow: []string{"10.1.0.0/16", "2001:db8::1"}},
+	}, l.families, nil)
+
+	l.Len(findings, 1)
+	l.Equal(2, findings[0].Rule)
+	l.Equal("is redundant, its traffic is already decided by rules 0, 1 with return", findings[0].Message)
+}
+
+func (l *LintTestSuite) Test_Lint_NeverMatch() {
+	findings := Lint([]config.Rule{
+		{Protocol: "icmp", Allow: []string{"2001:db8::1"}},
+		{Protocol: "icmp", Allow: []string{"10.0.0.1"}},
+	}, l.families, nil)
+
+	l.Len(findings, 1)
+	l.Equal(NeverMatch, findings[0].Check)
+	l.Equal(0, findings[0].Rule)
+
+	findings = Lint([]config.Rule{{Allow: []string{"2001:db8::1"}}}, []compiler.Family{compiler.IPv4}, nil)
+	l.Len(findings, 1)
+	l.Equal(NeverMatch, findings[0].Check)
+}
+
+func (l *LintTestSuite) Test_Lint_MissingInterface() {
+	findings := Lint([]config.Rule{
+		{Interface: []string{"docker0", "eth1", "br-+", "veth+"}},
+	}, l.families, []string{"lo", "eth0", "docker0", "br-4f2a"})
+
+	l.Equal([]Finding{
+		{Severity: Warning, Check: MissingInterface, Rule: 0, Message: `interface "eth1" does not exist on this host`},
+		{Severity: Warning, Check: MissingInterface, Rule: 0, Message: `interface "veth+" does not exist on this host`},
+	}, findings)
+}
@@ -0,0 +1,181 @@
+// Package metrics exposes the docker-firewall metrics in the Prometheus text
+// exposition format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/albertogviana/docker-firewall/firewall"
+)
+
+// Metrics counts the operations of a running docker-firewall
+type Metrics struct {
+	mu             sync.Mutex
+	applies        map[string]uint64
+	applySeconds   float64
+	verifyFailures uint64
+	driftEvents    uint64
+	reloads        map[string]uint64
+}
+
+// New returns Metrics with every counter at zero
+func New() *Metrics {
+	return &Metrics{
+		applies: map[string]uint64{"success": 0, "failure": 0},
+		reloads: map[string]uint64{"success": 0, "failure": 0},
+	}
+}
+
+// ObserveApply counts an apply of the rules and its duration
+func (m *Metrics) ObserveApply(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.applies[result(err)]++
+	m.applySeconds += duration.Seconds()
+}
+
+// VerifyFailed counts a verification that could not be completed
+func (m *Metrics) VerifyFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.verifyFailures++
+}
+
+// DriftDetected counts a verification finding the rules changed
+func (m *Metrics) DriftDetected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.driftEvents++
+}
+
+// ObserveReload counts a reload of the configuration
+func (m *Metrics) ObserveReload(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reloads[result(err)]++
+}
+
+// Write writes the metrics and the rule counters in the text exposition format
+func (m *Metrics) Write(w io.Writer, counters []firewall.RuleCounter) error {
+	m.mu.Lock()
+	applies := m.applies["success"] + m.applies["failure"]
+
+	var b bytes.Buffer
+	header(&b, "docker_firewall_applies_total", "counter", "Number of times the rules were applied.")
+	for _, r := range []string{"success", "failure"} {
+		fmt.Fprintf(&b, "docker_firewall_applies_total{result=%q} %d\n", r, m.applies[r])
+	}
+	header(&b, "docker_firewall_apply_duration_seconds", "summary", "Time spent applying the rules.")
+	fmt.Fprintf(&b, "docker_firewall_apply_duration_seconds_sum %s\n", strconv.FormatFloat(m.applySeconds, 'g', -1, 64))
+	fmt.Fprintf(&b, "docker_firewall_apply_duration_seconds_count %d\n", applies)
+	header(&b, "docker_firewall_verify_failures_total", "counter", "Number of verifications of the rules that failed to run.")
+	fmt.Fprintf(&b, "docker_firewall_verify_failures_total %d\n", m.verifyFailures)
+	header(&b, "docker_firewall_drift_events_total", "counter", "Number of verifications that found the rules changed.")
+	fmt.Fprintf(&b, "docker_firewall_drift_events_total %d\n", m.driftEvents)
+	header(&b, "docker_firewall_reloads_total", "counter", "Number of configuration reloads.")
+	for _, r := range []string{"success", "failure"} {
+		fmt.Fprintf(&b, "docker_firewall_reloads_total{result=%q} %d\n", r, m.reloads[r])
+	}
+	m.mu.Unlock()
+
+	writeCounters(&b, counters)
+
+	_, err := b.WriteTo(w)
+
+	return err
+}
+
+func writeCounters(b *bytes.Buffer, counters []firewall.RuleCounter) {
+	rules := []firewall.RuleCounter{}
+	defaults := []firewall.RuleCounter{}
+	for _, counter := range counters {
+		switch {
+		case counter.Rule == firewall.DefaultRuleIndex:
+			defaults = append(defaults, counter)
+		case counter.Rule >= 0 || ruleNames[counter.Rule] != "":
+			rules = append(rules, counter)
+		}
+	}
+
+	header(b, "docker_firewall_rule_packets_total", "counter", "Packets matched by the rules of a configuration rule, or added by docker-firewall.")
+	for _, counter := range rules {
+		fmt.Fprintf(b, "docker_firewall_rule_packets_total{%s} %d\n", ruleLabels(counter), counter.Packets)
+	}
+	header(b, "docker_firewall_rule_bytes_total", "counter", "Bytes matched by the rules of a configuration rule, or added by docker-firewall.")
+	for _, counter := range rules {
+		fmt.Fprintf(b, "docker_firewall_rule_bytes_total{%s} %d\n", ruleLabels(counter), counter.Bytes)
+	}
+	header(b, "docker_firewall_default_packets_total", "counter", "Packets that did not match any rule.")
+	for _, counter := range defaults {
+		fmt.Fprintf(b, "docker_firewall_default_packets_total{family=%s} %d\n", labelValue(counter.Family), counter.Packets)
+	}
+	header(b, "docker_firewall_default_bytes_total", "counter", "Bytes that did not match any rule.")
+	for _, counter := range defaults {
+		fmt.Fprintf(b, "docker_firewall_default_bytes_total{family=%s} %d\n", labelValue(counter.Family), counter.Bytes)
+	}
+}
+
+// Handler serves the metrics, reading the rule counters on each request
+func Handler(m *Metrics, counters func() ([]firewall.RuleCounter, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ruleCounters, err := counters()
+		if err != nil {
+			log.Printf("Failed to read the rule counters: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		err = m.Write(w, ruleCounters)
+		if err != nil {
+			log.Printf("Failed to write the metrics: %v", err)
+		}
+	})
+}
+
+func header(b *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+}
+
+// ruleNames are the rule label values of the rules added by docker-firewall
+var ruleNames = map[int]string{
+	firewall.EstablishedRuleIndex: "established",
+	firewall.DefaultLogRuleIndex:  "default_log",
+}
+
+func ruleLabels(counter firewall.RuleCounter) string {
+	rule, ok := ruleNames[counter.Rule]
+	if !ok {
+		rule = strconv.Itoa(counter.Rule)
+	}
+
+	return fmt.Sprintf("family=%s,rule=%s,name=%s", labelValue(counter.Family), labelValue(rule), labelValue(counter.Name))
+}
+
+// labelValue quotes a label value, escaping backslashes, quotes and new lines
+func labelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+
+	return `"` + value + `"`
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albertogviana/docker-firewall/firewall"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
+
+func (m *MetricsTestSuite) Test_Write() {
+	metrics := New()
+	metrics.ObserveApply(250*time.Millisecond, nil)
+	metrics.ObserveApply(500*time.Millisecond, errors.New("exit status 1"))
+	metrics.VerifyFailed()
+	metrics.DriftDetected()
+	metrics.DriftDetected()
+	metrics.ObserveReload(nil)
+
+	counters := []firewall.RuleCounter{
+		{Family: "IPv4", Rule: firewall.EstablishedRuleIndex, Counter: firewall.Counter{Packets: 1520, Bytes: 198745}},
+		{Family: "IPv4", Rule: 0, Name: "api", Counter: firewall.Counter{Packets: 15, Bytes: 900}},
+		{Family: "IPv4", Rule: 1, Name: `say "hi"`, Counter: firewall.Counter{Packets: 0, Bytes: 0}},
+		{Family: "IPv4", Rule: firewall.DefaultLogRuleIndex, Counter: firewall.Counter{Packets: 845, Bytes: 50700}},
+		{Family: "IPv4", Rule: firewall.DefaultRuleIndex, Counter: firewall.Counter{Packets: 845, Bytes: 50700}},
+	}
+
+	var b bytes.Buffer
+	m.NoError(metrics.Write(&b, counters))
+
+	expected := `# HELP docker_firewall_applies_total Number of times the rules were applied.
+# TYPE docker_firewall_applies_total counter
+docker_firewall_applies_total{result="success"} 1
+docker_firewall_applies_total{result="failure"} 1
+# HELP docker_firewall_apply_duration_seconds Time spent applying the rules.
+# TYPE docker_firewall_apply_duration_seconds summary
+docker_firewall_apply_duration_seconds_sum 0.75
+docker_firewall_apply_duration_seconds_count 2
+# HELP docker_firewall_verify_failures_total Number of verifications of the rules that failed to run.
+# TYPE docker_firewall_verify_failures_total counter
+docker_firewall_verify_failures_total 1
+# HELP docker_firewall_drift_events_total Number of verifications that found the rules changed.
+# TYPE docker_firewall_drift_events_total counter
+docker_firewall_drift_events_total 2
+# HELP docker_firewall_reloads_total Number of configuration reloads.
+# TYPE docker_firewall_reloads_total counter
+docker_firewall_reloads_total{result="success"} 1
+docker_firewall_reloads_total{result="failure"} 0
+# HELP docker_firewall_rule_packets_total Packets matched by the rules of a configuration rule, or added by docker-firewall.
+# TYPE docker_firewall_rule_packets_total counter
+docker_firewall_rule_packets_total{family="IPv4",rule="established",name=""} 1520
+docker_firewall_rule_packets_total{family="IPv4",rule="0",name="api"} 15
+docker_firewall_rule_packets_total{family="IPv4",rule="1",name="say \"hi\""} 0
+docker_firewall_rule_packets_total{family="IPv4",rule="default_log",name=""} 845
+# HELP docker_firewall_rule_bytes_total Bytes matched by the rules of a configuration rule, or added by docker-firewall.
+# TYPE docker_firewall_rule_bytes_total counter
+docker_firewall_rule_bytes_total{family="IPv4",rule="established",name=""} 198745
+docker_firewall_rule_bytes_total{family="IPv4",rule="0",name="api"} 900
+docker_firewall_rule_bytes_total{family="IPv4",rule="1",name="say \"hi\""} 0
+docker_firewall_rule_bytes_total{family="IPv4",rule="default_log",name=""} 50700
+# HELP docker_firewall_default_packets_total Packets that did not match any rule.
+# TYPE docker_firewall_default_packets_total counter
+docker_firewall_default_packets_total{family="IPv4"} 845
+# HELP docker_firewall_default_bytes_total Bytes that did not match any rule.
+# TYPE docker_firewall_default_bytes_total counter
+docker_firewall_default_bytes_total{family="IPv4"} 50700
+`
+	m.Equal(expected, b.String())
+}
+
+func (m *MetricsTestSuite) Test_Handler() {
+	handler := Handler(New(), func() ([]firewall.RuleCounter, error) {
+		return nil, errors.New("iptables is not available")
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	m.Equal(http.StatusOK, recorder.Code)
+	m.Equal("text/plain; version=0.0.4", recorder.Header().Get("Content-Type"))
+	m.Contains(recorder.Body.String(), "docker_firewall_applies_total{result=\"success\"} 0\n")
+	m.NotContains(recorder.Body.String(), "docker_firewall_rule_packets_total{")
+}
@@ -0,0 +1,62 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+)
+
+// nfnlgrpNFTables is the netlink multicast group notifying nftables changes
+const nfnlgrpNFTables = 7
+
+// WatchNetfilter calls notify whenever the netfilter ruleset changes, until
+// the context is done. Changes are notified by nftables, including the ones
+// made through iptables-nft, iptables-legacy does not notify its changes.
+func WatchNetfilter(ctx context.Context, notify func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("failed to open the netfilter netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: 1 << (nfnlgrpNFTables - 1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to netfilter changes: %v", err)
+	}
+
+	// wake up every second to check if the context is done
+	timeout := syscall.NsecToTimeval(1e9)
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 65536)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+
+		if err == syscall.ENOBUFS {
+			// messages were lost, the ruleset changed anyway
+			notify()
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read netfilter changes: %v", err)
+		}
+
+		if n > 0 {
+			notify()
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+)
+
+// WatchNetfilter is only supported on linux
+func WatchNetfilter(ctx context.Context, notify func()) error {
+	return errors.New("netfilter notifications are only supported on linux")
+}
@@ -0,0 +1,165 @@
+// Package reconciler keeps the firewall rules in place, reconciling them on
+// an interval and whenever a change is notified
+package reconciler
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Func reconciles the firewall rules. When force is false it may only apply
+// the rules if they are not in place, when true it must apply them.
+type Func func(ctx context.Context, force bool) error
+
+// Options defines how often the reconciler runs
+type Options struct {
+	// Interval between two reconciliations
+	Interval time.Duration
+	// MinBackoff is the delay before retrying a failed reconciliation, doubled on each failure
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between failed reconciliations
+	MaxBackoff time.Duration
+	// Jitter randomly spreads the delays by up to this fraction, a negative
+	// value disables it
+	Jitter float64
+}
+
+// DefaultOptions are the options used for the values that are not set
+var DefaultOptions = Options{
+	Interval:   10 * time.Second,
+	MinBackoff: time.Second,
+	MaxBackoff: 2 * time.Minute,
+	Jitter:     0.1,
+}
+
+// Reconciler runs a Func on an interval and immediately when triggered,
+// backing off exponentially while it fails
+type Reconciler struct {
+	reconcile Func
+	options   Options
+	random    func() float64
+
+	mu      sync.Mutex
+	force   bool
+	trigger chan struct{}
+}
+
+// New returns a Reconciler running reconcile with the given options
+func New(reconcile Func, options Options) *Reconciler {
+	if options.Interval <= 0 {
+		options.Interval = DefaultOptions.Interval
+	}
+
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = DefaultOptions.MinBackoff
+	}
+
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+
+	switch {
+	case options.Jitter == 0:
+		options.Jitter = DefaultOptions.Jitter
+	case options.Jitter < 0:
+		options.Jitter = 0
+	}
+
+	return &Reconciler{
+		reconcile: reconcile,
+		options:   options,
+		random:    rand.Float64,
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// Trigger requests an immediate reconciliation, which applies the rules only
+// if they are not in place
+func (r *Reconciler) Trigger() {
+	r.notify(false)
+}
+
+// Force requests an immediate reconciliation applying the rules
+func (r *Reconciler) Force() {
+	r.notify(true)
+}
+
+func (r *Reconciler) notify(force bool) {
+	r.mu.Lock()
+	r.force = r.force || force
+	r.mu.Unlock()
+
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run reconciles immediately and then on every interval or trigger, until
+// the context is done
+func (r *Reconciler) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		force := false
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		case <-r.trigger:
+			r.mu.Lock()
+			force = r.force
+			r.force = false
+			r.mu.Unlock()
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		err := r.reconcile(ctx, force)
+		if err != nil {
+			failures++
+			delay := r.backoff(failures)
+			log.Printf("Reconciliation failed, retrying in %s: %v", delay, err)
+			timer.Reset(delay)
+			continue
+		}
+
+		failures = 0
+		timer.Reset(r.jitter(r.options.Interval))
+	}
+}
+
+// backoff returns the delay before the next attempt after the given number of failures
+func (r *Reconciler) backoff(failures int) time.Duration {
+	delay := r.options.MinBackoff
+	for i := 1; i < failures && delay < r.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.options.MaxBackoff {
+		delay = r.options.MaxBackoff
+	}
+
+	return r.jitter(delay)
+}
+
+func (r *Reconciler) jitter(delay time.Duration) time.Duration {
+	if r.options.Jitter == 0 {
+		return delay
+	}
+
+	spread := float64(delay) * r.options.Jitter * (2*r.random() - 1)
+
+	return delay + time.Duration(spread)
+}
@@ -0,0 +1,105 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ReconcilerTestSuite struct {
+	suite.Suite
+}
+
+func TestReconcilerTestSuite(t *testing.T) {
+	suite.Run(t, new(ReconcilerTestSuite))
+}
+
+func (r *ReconcilerTestSuite) Test_Run_IntervalAndTriggers() {
+	calls := make(chan bool, 10)
+	reconciler := New(func(ctx context.Context, force bool) error {
+		calls <- force
+		return nil
+	}, Options{Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- reconciler.Run(ctx)
+	}()
+
+	r.False(r.receive(calls), "reconciles when it starts")
+
+	reconciler.Trigger()
+	r.False(r.receive(calls), "trigger verifies the rules")
+
+	reconciler.Force()
+	r.True(r.receive(calls), "force applies the rules")
+
+	cancel()
+	r.Equal(context.Canceled, <-done)
+}
+
+func (r *ReconcilerTestSuite) Test_Run_BacksOffOnErrors() {
+	calls := make(chan bool, 10)
+	failures := 2
+	reconciler := New(func(ctx context.Context, force bool) error {
+		calls <- force
+		if failures > 0 {
+			failures--
+			return errors.New("iptables failed")
+		}
+		return nil
+	}, Options{Interval: time.Hour, MinBackoff: time.Millisecond, Jitter: -1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reconciler.Run(ctx)
+
+	for i := 0; i < 3; i++ {
+		r.receive(calls)
+	}
+
+	select {
+	case <-calls:
+		r.Fail("reconciled again before the interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (r *ReconcilerTestSuite) Test_Backoff() {
+	reconciler := New(nil, Options{MinBackoff: time.Second, MaxBackoff: 10 * time.Second, Jitter: -1})
+
+	r.Equal(time.Second, reconciler.backoff(1))
+	r.Equal(2*time.Second, reconciler.backoff(2))
+	r.Equal(8*time.Second, reconciler.backoff(4))
+	r.Equal(10*time.Second, reconciler.backoff(5))
+	r.Equal(10*time.Second, reconciler.backoff(60))
+}
+
+func (r *ReconcilerTestSuite) Test_DefaultJitter() {
+	r.Equal(DefaultOptions.Jitter, New(nil, Options{Interval: time.Minute}).options.Jitter)
+	r.Equal(0.0, New(nil, Options{Jitter: -1}).options.Jitter)
+}
+
+func (r *ReconcilerTestSuite) Test_Jitter() {
+	reconciler := New(nil, Options{Jitter: 0.1})
+
+	reconciler.random = func() float64 { return 0 }
+	r.Equal(9*time.Second, reconciler.jitter(10*time.Second))
+
+	reconciler.random = func() float64 { return 1 }
+	r.Equal(11*time.Second, reconciler.jitter(10*time.Second))
+}
+
+func (r *ReconcilerTestSuite) receive(calls chan bool) bool {
+	select {
+	case force := <-calls:
+		return force
+	case <-time.After(time.Second):
+		r.FailNow("reconciliation did not run")
+		return false
+	}
+}